@@ -0,0 +1,571 @@
+package pitch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArchiveOptions configures how a file tree is walked and stored.
+// A nil *ArchiveOptions is equivalent to the zero value.
+type ArchiveOptions struct {
+	// Prefix, if set, is joined in front of every header name.
+	Prefix string
+	// Filter, if set, is called for every entry in the tree.
+	// Entries for which it returns false are skipped; for directories
+	// this skips the whole subtree.
+	Filter func(name string, entry fs.DirEntry) bool
+	// Symlinks selects how symbolic links are archived.
+	// Reading links requires fsys to implement fs.ReadLinkFS.
+	Symlinks SymlinkPolicy
+
+	// Metadata records the mode, modification time and, where available, owner
+	// of every entry in its header data.
+	Metadata bool
+	// ModTimeClamp, if not zero, replaces every modification time later than it.
+	// See SourceDateEpoch.
+	ModTimeClamp time.Time
+	// ZeroOwner records every entry as owned by uid and gid 0.
+	ZeroOwner bool
+	// Hardlinks stores every further name of a file with several hard links as an empty
+	// entry of type EntryTypeHardlink naming the first one, instead of storing its content again.
+	// Links are found through device and inode numbers, on unix only.
+	Hardlinks bool
+	// Sparse stores only the data segments of files with holes, along with a map of them
+	// (see DataKeySparseMap), so holes take no space in the archive. Holes are found with
+	// SEEK_DATA and SEEK_HOLE, on linux only; elsewhere every file is stored in full.
+	Sparse bool
+	// Dedup records the digest of every file under DataKeyDigest and stores files whose
+	// content was already written as references to the first copy, see EntryTypeRef.
+	// Files that are not read ahead of the writer are read twice, once to be hashed.
+	Dedup bool
+
+	// Concurrency is the number of files read ahead of the writer at once.
+	// Values below 2 archive one file at a time.
+	// Entries are written in the same order either way.
+	Concurrency int
+	// MemoryBudget bounds the number of bytes read ahead of the writer when Concurrency is above 1.
+	// Files larger than the budget are streamed by the writer instead.
+	// Defaults to DefaultMemoryBudget.
+	MemoryBudget int64
+
+	// Observer, if set, is notified of every entry written by ArchiveFS or ArchiveDir.
+	Observer Observer
+
+	// Index appends an index of every entry to the archive, along with the values of IndexDataKeys,
+	// see Writer.EnableIndex. It is used by ArchiveFS and ArchiveDir only.
+	Index         bool
+	IndexDataKeys []string
+}
+
+// ArchiveFS writes every file under root in fsys into dst as a pitch archive.
+// Header names are relative to the parent of root, so the base name of root
+// is kept as the first path element (unless root is ".").
+//
+// Entries are written in lexical order, so archiving the same tree twice with
+// ModTimeClamp set (or Metadata unset) produces identical archives.
+//
+// Absolute symlink targets do not name anything inside of an arbitrary fs.FS,
+// so links with absolute targets cannot be followed.
+func ArchiveFS(dst io.WriteCloser, fsys fs.FS, root string, opts *ArchiveOptions) error {
+	return ArchiveFSContext(context.Background(), dst, fsys, root, opts)
+}
+
+// ArchiveFSContext is like ArchiveFS but gives up once ctx is done.
+func ArchiveFSContext(ctx context.Context, dst io.WriteCloser, fsys fs.FS, root string, opts *ArchiveOptions) error {
+	return archiveTrees(ctx, dst, []string{root}, func(w *Writer, root string) (*archiver, error) {
+		return newArchiver(w, fsys, root, opts), nil
+	})
+}
+
+// closeWriter closes w after an archive was written to it with the given outcome,
+// and returns the first error.
+func closeWriter(w *Writer, err error) error {
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// WalkFSFunc returns an fs.WalkDirFunc that writes every file it visits into w.
+// It is meant to be used with fs.WalkDir(fsys, root, ...).
+// Files are always written one at a time, opts.Concurrency is ignored.
+func WalkFSFunc(w *Writer, fsys fs.FS, root string, opts *ArchiveOptions) fs.WalkDirFunc {
+	a := newArchiver(w, fsys, root, opts)
+	return a.walkDirFunc
+}
+
+type archiver struct {
+	ctx    context.Context
+	w      *Writer
+	fsys   fs.FS
+	root   string
+	parent string
+	opts   ArchiveOptions
+	// osDir is the OS directory fsys is rooted at, if any. It makes absolute symlink targets
+	// within it resolvable, and lets links to files outside of fsys be followed through the OS.
+	osDir string
+	// rootReal is root with all symlinks resolved.
+	rootReal string
+	// emit is called with every entry the walk decides to archive, in walk order.
+	emit func(*archiveEntry) error
+	// inodes maps the files with several links seen so far to the name they were first stored under,
+	// for ArchiveOptions.Hardlinks.
+	inodes map[inode]string
+	// seen maps the digests of the files written so far to their content, for ArchiveOptions.Dedup.
+	seen map[string]dedupTarget
+}
+
+// archiveEntry is a single entry found by the walk.
+type archiveEntry struct {
+	name string
+	// src is the path of the content in fsys, it is empty for entries without content.
+	src  string
+	fsys fs.FS
+	size int64
+	data map[string][]string
+	// segments holds the data segments of src if it is stored sparse, see ArchiveOptions.Sparse,
+	// and sparseSize its logical size.
+	segments   []sparseSegment
+	sparseSize int64
+	// file is src, when it was already opened by the walk. openContent takes it over.
+	file fs.File
+}
+
+// closeFile closes the file held by e, if any.
+func (e *archiveEntry) closeFile() {
+	if e.file != nil {
+		e.file.Close()
+		e.file = nil
+	}
+}
+
+func newArchiver(w *Writer, fsys fs.FS, root string, opts *ArchiveOptions) *archiver {
+	root = path.Clean(root)
+	a := archiver{
+		ctx:    context.Background(),
+		w:      w,
+		fsys:   fsys,
+		root:   root,
+		parent: path.Dir(root),
+	}
+	if opts != nil {
+		a.opts = *opts
+	}
+	if a.opts.Hardlinks {
+		a.inodes = make(map[inode]string)
+	}
+	if a.opts.Dedup {
+		a.seen = make(map[string]dedupTarget)
+	}
+	a.emit = func(e *archiveEntry) error {
+		return a.writeEntry(e, nil)
+	}
+	return &a
+}
+
+// archive runs walk, which must drive a.walkDirFunc, writing entries in parallel
+// if the options ask for it.
+func (a *archiver) archive(walk func() error) error {
+	if a.opts.Concurrency < 2 {
+		return walk()
+	}
+	return a.archiveParallel(walk)
+}
+
+func (a *archiver) walkDirFunc(p string, entry fs.DirEntry, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if a.rootReal == "" {
+		if a.rootReal, err = resolvePath(a.fsys, a.root, a.osDir); err != nil {
+			return fmt.Errorf("error resolving root: %w", err)
+		}
+	}
+
+	var (
+		src   = path.Join(a.rootReal, relPath(p, a.root))
+		stack = []string{a.rootReal}
+	)
+	return a.visit(src, p, entry, stack)
+}
+
+// walk archives the directory dir, which is reached through the path virtual.
+// The stack holds every directory entered by following a link so far.
+func (a *archiver) walk(dir, virtual string, stack []string) error {
+	return fs.WalkDir(a.fsys, dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir {
+			return nil
+		}
+		return a.visit(p, path.Join(virtual, relPath(p, dir)), entry, stack)
+	})
+}
+
+// visit archives a single entry.
+// The path src is where the entry lives in fsys, virtual is where the walk found it.
+func (a *archiver) visit(src, virtual string, entry fs.DirEntry, stack []string) error {
+	if err := a.ctx.Err(); err != nil {
+		return fmt.Errorf("error archiving %s: %w", virtual, err)
+	}
+
+	if filter := a.opts.Filter; filter != nil && !filter(virtual, entry) {
+		if entry.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	}
+
+	if entry.IsDir() {
+		return nil
+	}
+
+	if entry.Type()&fs.ModeSymlink != 0 {
+		return a.visitLink(src, virtual, stack)
+	}
+
+	info, err := entry.Info()
+	if err != nil {
+		return fmt.Errorf("error getting file info: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	return a.writeFile(a.fsys, src, virtual, info)
+}
+
+func (a *archiver) visitLink(src, virtual string, stack []string) error {
+	switch a.opts.Symlinks {
+	case SymlinkSkip:
+		return nil
+	case SymlinkStore:
+		return a.writeLink(src, virtual)
+	}
+
+	target, err := resolvePath(a.fsys, src, a.osDir)
+	switch {
+	case errors.Is(err, errOutsideFS) && a.osDir != "" && a.opts.Symlinks != SymlinkFollowWithinRoot:
+		return a.visitOutsideLink(src, virtual)
+	case errors.Is(err, errSymlinkLoop), errors.Is(err, errOutsideFS), errors.Is(err, fs.ErrNotExist):
+		return a.cannotFollow(src, virtual, err)
+	case err != nil:
+		return fmt.Errorf("error resolving symlink [%s]: %w", src, err)
+	}
+
+	if a.opts.Symlinks == SymlinkFollowWithinRoot && !isWithin(target, a.rootReal) {
+		return a.writeLink(src, virtual)
+	}
+
+	info, err := fs.Stat(a.fsys, target)
+	if err != nil {
+		return fmt.Errorf("error getting file info: %w", err)
+	}
+
+	if info.IsDir() {
+		// following a link to a directory that contains the link, or any directory
+		// we have already entered, would walk the same tree forever
+		if isWithin(path.Dir(src), target) {
+			return a.cannotFollow(src, virtual, errSymlinkLoop)
+		}
+		for _, dir := range stack {
+			if isWithin(dir, target) {
+				return a.cannotFollow(src, virtual, errSymlinkLoop)
+			}
+		}
+		return a.walk(target, virtual, append(stack, target))
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	return a.writeFile(a.fsys, target, virtual, info)
+}
+
+// cannotFollow archives the link src, which could not be followed because of err, as the policy says.
+func (a *archiver) cannotFollow(src, virtual string, err error) error {
+	if a.opts.Symlinks == SymlinkFollow {
+		return fmt.Errorf("error following symlink [%s]: %w", src, err)
+	}
+	return a.writeLink(src, virtual)
+}
+
+// visitOutsideLink follows the link src, which leads out of fsys, through the OS.
+// Only links to regular files can be followed this way.
+func (a *archiver) visitOutsideLink(src, virtual string) error {
+	target, err := filepath.EvalSymlinks(filepath.Join(a.osDir, filepath.FromSlash(src)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return a.cannotFollow(src, virtual, err)
+	}
+	if err != nil {
+		return fmt.Errorf("error resolving symlink [%s]: %w", src, err)
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("error getting file info: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return a.cannotFollow(src, virtual, errOutsideFS)
+	}
+
+	return a.writeFile(os.DirFS(filepath.Dir(target)), filepath.Base(target), virtual, info)
+}
+
+// writeFile archives the regular file src of fsys as virtual.
+func (a *archiver) writeFile(fsys fs.FS, src, virtual string, info fs.FileInfo) error {
+	e := archiveEntry{
+		name: a.headerName(virtual),
+		src:  src,
+		fsys: fsys,
+		size: info.Size(),
+		data: fileData(info, &a.opts),
+	}
+
+	if id, ok := fileID(info); ok && a.opts.Hardlinks {
+		if first, ok := a.inodes[id]; ok {
+			if e.data == nil {
+				e.data = make(map[string][]string)
+			}
+			e.data[DataKeyType] = []string{EntryTypeHardlink}
+			e.data[DataKeyLinkTarget] = []string{first}
+			return a.emit(&archiveEntry{
+				name: e.name,
+				data: e.data,
+			})
+		}
+		a.inodes[id] = e.name
+	}
+
+	if a.opts.Sparse {
+		file, err := fsys.Open(src)
+		if err != nil {
+			return fmt.Errorf("error opening file: %w", err)
+		}
+		// the file is kept open for its content to be read from, see openContent
+		e.file = file
+
+		// only files that are *os.File can be checked
+		var segs []sparseSegment
+		if f, ok := file.(*os.File); ok {
+			if segs, err = detectSparse(f, e.size); err != nil {
+				e.closeFile()
+				return fmt.Errorf("error finding holes in %s: %w", src, err)
+			}
+		}
+		if segs != nil {
+			if e.data == nil {
+				e.data = make(map[string][]string)
+			}
+			e.data[DataKeySparseMap] = formatSparseMap(segs)
+			e.data[DataKeySparseSize] = []string{strconv.FormatInt(e.size, 10)}
+			e.segments = segs
+			e.sparseSize = e.size
+			e.size = sparseDataSize(segs)
+		}
+	}
+
+	if err := a.emit(&e); err != nil {
+		e.closeFile()
+		return err
+	}
+	return nil
+}
+
+func (a *archiver) writeLink(src, virtual string) error {
+	target, err := fs.ReadLink(a.fsys, src)
+	if err != nil {
+		return fmt.Errorf("error reading symlink: %w", err)
+	}
+
+	info, err := fs.Lstat(a.fsys, src)
+	if err != nil {
+		return fmt.Errorf("error getting file info: %w", err)
+	}
+
+	data := fileData(info, &a.opts)
+	if data == nil {
+		data = make(map[string][]string, 2)
+	}
+	data[DataKeyType] = []string{EntryTypeSymlink}
+	data[DataKeyLinkTarget] = []string{target}
+
+	return a.emit(&archiveEntry{
+		name: a.headerName(virtual),
+		data: data,
+	})
+}
+
+// writeEntry writes e into the archive.
+// The content of e is taken from content if it is not nil, otherwise it is read from fsys.
+func (a *archiver) writeEntry(e *archiveEntry, content []byte) error {
+	defer e.closeFile()
+
+	var digest string
+	if a.opts.Dedup && e.src != "" {
+		var err error
+		if digest, err = a.digest(e, content); err != nil {
+			return fmt.Errorf("error hashing file [%s]: %w", e.src, err)
+		}
+
+		e.data = maps.Clone(e.data)
+		if e.data == nil {
+			e.data = make(map[string][]string)
+		}
+		e.data[DataKeyDigest] = []string{digest}
+
+		// sparse files may only share the data of files with the same holes
+		key := digest + strings.Join(e.data[DataKeySparseMap], ",")
+		if target, ok := a.seen[key]; ok && 0 < e.size && target.size == e.size {
+			if _, err := a.w.WriteReference(e.name, target.offset, target.size, e.data); err != nil {
+				return fmt.Errorf("error writing reference (%s, %d): %w", e.name, e.size, err)
+			}
+			return nil
+		}
+	}
+
+	if _, err := a.w.WriteHeader(e.name, e.size, e.data); err != nil {
+		return fmt.Errorf("error writing header (%s, %d): %w", e.name, e.size, err)
+	}
+	if digest != "" {
+		a.seen[digest+strings.Join(e.data[DataKeySparseMap], ",")] = dedupTarget{
+			offset: a.w.Offset(),
+			size:   e.size,
+		}
+	}
+
+	if content != nil {
+		if len(content) == 0 {
+			return nil
+		}
+		if _, err := a.w.Write(content); err != nil {
+			return fmt.Errorf("error copying file [%s]: %w", e.src, err)
+		}
+		return nil
+	}
+
+	if e.src == "" {
+		return nil
+	}
+
+	file, err := a.openContent(e)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(a.w, &contextReader{ctx: a.ctx, r: file}); err != nil {
+		file.Close()
+		return fmt.Errorf("error copying file [%s]: %w", e.src, err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error closing file: %w", err)
+	}
+
+	return nil
+}
+
+// digest returns the digest of the logical content of e, whose stored content is taken from content if it is not nil.
+func (a *archiver) digest(e *archiveEntry, content []byte) (string, error) {
+	var r io.Reader
+	if content != nil {
+		r = bytes.NewReader(content)
+	} else {
+		file, err := a.openContent(e)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		r = &contextReader{ctx: a.ctx, r: file}
+	}
+
+	if e.segments != nil {
+		r = &sparseReader{
+			r:    r,
+			segs: e.segments,
+			size: e.sparseSize,
+		}
+	}
+	return contentDigest(r)
+}
+
+// openContent opens the content of e that is stored in the archive,
+// which is only the data segments of a sparse file.
+func (a *archiver) openContent(e *archiveEntry) (io.ReadCloser, error) {
+	file, err := e.takeFile()
+	if err != nil {
+		return nil, err
+	}
+	if e.segments == nil {
+		return file, nil
+	}
+
+	ra, ok := file.(io.ReaderAt)
+	if !ok {
+		file.Close()
+		return nil, fmt.Errorf("error reading sparse file %s: no random access", e.src)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{sparseContent(ra, e.segments), file}, nil
+}
+
+// takeFile returns the file held by e, rewound, or opens src if e holds none.
+func (e *archiveEntry) takeFile() (fs.File, error) {
+	file := e.file
+	e.file = nil
+	if file == nil {
+		f, err := e.fsys.Open(e.src)
+		if err != nil {
+			return nil, fmt.Errorf("error opening file: %w", err)
+		}
+		return f, nil
+	}
+
+	// finding holes moves the offset of the file
+	if seeker, ok := file.(io.Seeker); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error rewinding file: %w", err)
+		}
+	}
+	return file, nil
+}
+
+// headerName returns the name p is stored under in the archive.
+func (a *archiver) headerName(p string) string {
+	name := p
+	if a.parent != "." {
+		name = strings.TrimPrefix(p, a.parent+"/")
+	}
+	if a.opts.Prefix != "" {
+		name = path.Join(a.opts.Prefix, name)
+	}
+	return name
+}
+
+// relPath returns p relative to dir, where p is dir or lies beneath it.
+func relPath(p, dir string) string {
+	switch {
+	case p == dir:
+		return "."
+	case dir == ".":
+		return p
+	}
+	return strings.TrimPrefix(p, dir+"/")
+}
@@ -0,0 +1,115 @@
+package pitch
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+func TestArchiveFS(t *testing.T) {
+	var (
+		is = is.New(t)
+
+		fsys = fstest.MapFS{
+			"site/index.html":     {Data: []byte("<h1>hi</h1>")},
+			"site/css/main.css":   {Data: []byte("h1 {}")},
+			"site/js/app.js":      {Data: []byte("alert(1)")},
+			"site/empty/.keep":    {Data: []byte{}},
+			"other/unrelated.txt": {Data: []byte("nope")},
+		}
+
+		tests = []struct {
+			name     string
+			root     string
+			opts     *ArchiveOptions
+			expected map[string]string
+		}{
+			{
+				name: "root_dir",
+				root: "site",
+				expected: map[string]string{
+					"site/index.html":   "<h1>hi</h1>",
+					"site/css/main.css": "h1 {}",
+					"site/js/app.js":    "alert(1)",
+					"site/empty/.keep":  "",
+				},
+			},
+			{
+				name: "nested_root",
+				root: "site/css",
+				expected: map[string]string{
+					"css/main.css": "h1 {}",
+				},
+			},
+			{
+				name: "dot_root",
+				root: ".",
+				opts: &ArchiveOptions{
+					Filter: func(name string, entry fs.DirEntry) bool {
+						return name != "site"
+					},
+				},
+				expected: map[string]string{
+					"other/unrelated.txt": "nope",
+				},
+			},
+			{
+				name: "prefix",
+				root: "site/js",
+				opts: &ArchiveOptions{
+					Prefix: "static",
+				},
+				expected: map[string]string{
+					"static/js/app.js": "alert(1)",
+				},
+			},
+		}
+	)
+
+	is.NoErr(fstest.TestFS(fsys, "site/index.html"))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				is  = is.New(t)
+				buf = bytes.NewBuffer(nil)
+			)
+
+			err := ArchiveFS(&nopCloser{buf}, fsys, test.root, test.opts)
+			is.NoErr(err)
+
+			found, _, err := readArchive(NewReader(buf))
+			is.NoErr(err)
+			is.Equal(found, test.expected)
+		})
+	}
+}
+
+// readArchive reads every entry in r, returning the contents and headers by name.
+func readArchive(r Reader) (map[string]string, map[string]*Header, error) {
+	var (
+		contents = make(map[string]string)
+		headers  = make(map[string]*Header)
+	)
+	for {
+		hdr, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return contents, headers, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		contents[hdr.Name] = string(data)
+		headers[hdr.Name] = hdr
+	}
+}
@@ -0,0 +1,58 @@
+package pitch
+
+import (
+	"errors"
+	"maps"
+)
+
+// ArchiveDataName is the name of the entry that holds the archive data of an archive,
+// see Writer.SetArchiveData. Readers that predate archive data see it as an empty file,
+// the same way old tar implementations see "pax_global_header".
+const ArchiveDataName = "pitch_archive_data"
+
+// ErrArchiveDataTooLate is returned when archive data is set after the first entry of an archive.
+var ErrArchiveDataTooLate = errors.New("pitch: archive data must be set before the first entry")
+
+// SetArchiveData stores data, such as the creator, build ID or creation time,
+// as the archive data of the archive. It must be called before any entry is written.
+//
+// The data is written as an empty entry named ArchiveDataName, marked with EntryTypeArchiveData.
+// Readers returned by NewReader skip it and hand its data out through ArchiveData,
+// and tables of contents leave it out. CompactTableOfContents keeps it, see its ArchiveData method.
+// It is not reported to the observer of wtr.
+func (wtr *Writer) SetArchiveData(data map[string][]string) error {
+	if wtr.w == nil {
+		return ErrClosed
+	}
+	if wtr.offset != 0 {
+		return ErrArchiveDataTooLate
+	}
+
+	payload := EncodeHeader(Header{
+		Name: ArchiveDataName,
+		Data: archiveEntryData(data),
+	})
+	n, err := wtr.w.Write(payload)
+	wtr.offset += int64(n)
+	return err
+}
+
+// isArchiveData reports whether the entry with the given name and data holds archive data.
+func isArchiveData(name string, data map[string][]string) bool {
+	return name == ArchiveDataName && EntryType(data) == EntryTypeArchiveData
+}
+
+// archiveEntryData returns the header data of the entry that holds the archive data data.
+func archiveEntryData(data map[string][]string) map[string][]string {
+	entryData := make(map[string][]string, len(data)+1)
+	maps.Copy(entryData, data)
+	entryData[DataKeyType] = []string{EntryTypeArchiveData}
+	return entryData
+}
+
+// archiveDataOf returns the archive data held by the header data of an archive data entry.
+func archiveDataOf(entryData map[string][]string) map[string][]string {
+	data := maps.Clone(entryData)
+	delete(data, DataKeyType)
+	return data
+}
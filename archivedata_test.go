@@ -0,0 +1,225 @@
+package pitch
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func newArchiveDataArchive(t *testing.T, data map[string][]string) []byte {
+	var (
+		is  = is.New(t)
+		buf = bytes.NewBuffer(nil)
+		w   = NewWriter(buf)
+	)
+	is.NoErr(w.SetArchiveData(data))
+	writeTestEntries(t, w, testEntry{name: "a.txt", content: "AAA"})
+	is.NoErr(w.Close())
+	return buf.Bytes()
+}
+
+func TestArchiveData_Reader(t *testing.T) {
+	var (
+		is   = is.New(t)
+		data = map[string][]string{"Creator": {"ci"}, "Commit": {"abc123"}}
+		r    = NewReader(bytes.NewReader(newArchiveDataArchive(t, data)))
+	)
+
+	// available before the first entry is read
+	is.Equal(ArchiveData(r), data)
+
+	hdr, err := r.Next()
+	is.NoErr(err)
+	is.Equal(hdr.Name, "a.txt")
+	content, err := io.ReadAll(r)
+	is.NoErr(err)
+	is.Equal(string(content), "AAA")
+
+	_, err = r.Next()
+	is.True(errors.Is(err, io.EOF))
+	is.Equal(ArchiveData(r), data)
+}
+
+func TestArchiveData_None(t *testing.T) {
+	var (
+		is      = is.New(t)
+		archive = writeTestArchive(t, testEntry{name: "a.txt", content: "A"})
+	)
+
+	r := NewReader(bytes.NewReader(archive))
+	is.Equal(ArchiveData(r), nil)
+	hdr, err := r.Next()
+	is.NoErr(err)
+	is.Equal(hdr.Name, "a.txt")
+
+	ct, err := BuildCompactTableOfContents(archive)
+	is.NoErr(err)
+	is.Equal(ct.ArchiveData(), nil)
+}
+
+func TestArchiveData_TooLate(t *testing.T) {
+	var (
+		is = is.New(t)
+		w  = NewWriter(io.Discard)
+	)
+	_, err := w.WriteHeader("a.txt", 0, nil)
+	is.NoErr(err)
+	is.True(errors.Is(w.SetArchiveData(map[string][]string{"k": {"v"}}), ErrArchiveDataTooLate))
+}
+
+func TestArchiveData_TableOfContents(t *testing.T) {
+	var (
+		is      = is.New(t)
+		data    = map[string][]string{"Build-Id": {"42"}}
+		archive = newArchiveDataArchive(t, data)
+	)
+
+	// tables of contents hold entries only, the reader they were built from has the archive data
+	r := NewReader(bytes.NewReader(archive))
+	toc, err := BuildTableOfContents(r)
+	is.NoErr(err)
+	is.Equal(len(toc), 1)
+	is.Equal(toc["a.txt"].Size, uint64(3))
+	is.Equal(len(TableToList[ListOfContents](toc)), 1)
+	is.Equal(ArchiveData(r), data)
+
+	ct, err := BuildCompactTableOfContents(archive)
+	is.NoErr(err)
+	is.Equal(ct.Len(), 1)
+	is.Equal(ct.ArchiveData(), data)
+	is.Equal(ct.TableOfContents(), toc)
+
+	sidecar := bytes.NewBuffer(nil)
+	_, err = toc.WriteTo(sidecar)
+	is.NoErr(err)
+	loaded, err := ReadTableOfContents(sidecar)
+	is.NoErr(err)
+	is.Equal(loaded, toc)
+
+	dst := t.TempDir()
+	is.NoErr(ExtractAt(t.Context(), dst, bytes.NewReader(archive), toc, nil))
+	_, err = NewFS(bytes.NewReader(archive), toc)
+	is.NoErr(err)
+}
+
+// Readers that predate archive data see an empty file.
+func TestArchiveData_OldReaders(t *testing.T) {
+	var (
+		is      = is.New(t)
+		archive = newArchiveDataArchive(t, map[string][]string{"k": {"v"}})
+		r       = bytes.NewReader(archive)
+	)
+
+	hdr, err := DecodeHeader(r)
+	is.NoErr(err)
+	is.Equal(hdr.Name, ArchiveDataName)
+	is.Equal(hdr.Size, uint64(0))
+
+	hdr, err = DecodeHeader(r)
+	is.NoErr(err)
+	is.Equal(hdr.Name, "a.txt")
+}
+
+func TestArchiveData_Tar(t *testing.T) {
+	var (
+		is      = is.New(t)
+		data    = map[string][]string{"Creator": {"ci"}, DataKeyPAXPrefix + "VENDOR.note": {"hi"}}
+		archive = newArchiveDataArchive(t, data)
+		tarBuf  = bytes.NewBuffer(nil)
+	)
+
+	is.NoErr(ToTar(NewReader(bytes.NewReader(archive)), tarBuf))
+
+	th, err := tar.NewReader(bytes.NewReader(tarBuf.Bytes())).Next()
+	is.NoErr(err)
+	is.Equal(th.Typeflag, byte(tar.TypeXGlobalHeader))
+	is.Equal(th.PAXRecords["VENDOR.note"], "hi")
+
+	pchBuf := bytes.NewBuffer(nil)
+	w := NewWriter(pchBuf)
+	is.NoErr(FromTar(tarBuf, w))
+	is.NoErr(w.Close())
+	is.Equal(ArchiveData(NewReader(bytes.NewReader(pchBuf.Bytes()))), data)
+}
+
+func TestArchiveData_Zip(t *testing.T) {
+	var (
+		is      = is.New(t)
+		data    = map[string][]string{DataKeyComment: {"release build"}}
+		archive = newArchiveDataArchive(t, data)
+		zipBuf  = bytes.NewBuffer(nil)
+	)
+
+	is.NoErr(ToZip(bytes.NewReader(archive), int64(len(archive)), zipBuf))
+
+	zr, err := zip.NewReader(bytes.NewReader(zipBuf.Bytes()), int64(zipBuf.Len()))
+	is.NoErr(err)
+	is.Equal(zr.Comment, "release build")
+	is.Equal(len(zr.File), 1)
+
+	pchBuf := bytes.NewBuffer(nil)
+	w := NewWriter(pchBuf)
+	is.NoErr(FromZip(bytes.NewReader(zipBuf.Bytes()), int64(zipBuf.Len()), w))
+	is.NoErr(w.Close())
+	is.Equal(ArchiveData(NewReader(bytes.NewReader(pchBuf.Bytes()))), data)
+}
+
+// An entry may be named like the archive data entry without being hidden by it.
+func TestArchiveData_EntryNamedArchiveData(t *testing.T) {
+	var (
+		is   = is.New(t)
+		buf  = bytes.NewBuffer(nil)
+		w    = NewWriter(buf)
+		data = map[string][]string{"k": {"v"}}
+	)
+	is.NoErr(w.SetArchiveData(data))
+	_, err := w.WriteHeader(ArchiveDataName, 3, nil)
+	is.NoErr(err)
+	_, err = w.Write([]byte("AAA"))
+	is.NoErr(err)
+	is.NoErr(w.Close())
+	archive := buf.Bytes()
+
+	r := NewReader(bytes.NewReader(archive))
+	toc, err := BuildTableOfContents(r)
+	is.NoErr(err)
+	is.Equal(ArchiveData(r), data)
+	content, err := io.ReadAll(toc[ArchiveDataName].Content(bytes.NewReader(archive)))
+	is.NoErr(err)
+	is.Equal(string(content), "AAA")
+
+	var names []string
+	for name := range toc.ByName() {
+		names = append(names, name)
+	}
+	is.Equal(names, []string{ArchiveDataName})
+}
+
+// The archive data skipped by the reader of a concatenated archive still counts towards the offsets of entries.
+func TestArchiveData_Cat(t *testing.T) {
+	var (
+		is     = is.New(t)
+		first  = newArchiveDataArchive(t, map[string][]string{"k": {"v"}})
+		second = newArchiveDataArchive(t, map[string][]string{"k": {"w"}})
+	)
+	// the second archive is renamed so both entries are kept
+	second = bytes.Replace(second, []byte("a.txt"), []byte("b.txt"), 1)
+	second = bytes.Replace(second, []byte("AAA"), []byte("BBB"), 1)
+	archive := append(slices.Clone(first), second...)
+
+	r := Cat(NewReader(bytes.NewReader(first)), NewReader(bytes.NewReader(second)))
+	toc, err := BuildTableOfContents(r)
+	is.NoErr(err)
+	is.Equal(ArchiveData(r), map[string][]string{"k": {"v"}})
+	for name, want := range map[string]string{"a.txt": "AAA", "b.txt": "BBB"} {
+		content, err := io.ReadAll(toc[name].Content(bytes.NewReader(archive)))
+		is.NoErr(err)
+		is.Equal(string(content), want)
+	}
+}
@@ -0,0 +1,206 @@
+package pitch
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Header data is stored byte for byte: keys and values may hold arbitrary bytes, not only text.
+// The functions below convert between the string form used by Header.Data and byte slices.
+
+// DataFromBytes returns header data holding the byte slice values of data.
+// Keys are converted with string(key) by the caller; they may hold arbitrary bytes too.
+func DataFromBytes(data map[string][][]byte) map[string][]string {
+	if data == nil {
+		return nil
+	}
+	strData := make(map[string][]string, len(data))
+	for k, values := range data {
+		strValues := make([]string, len(values))
+		for i, v := range values {
+			strValues[i] = string(v)
+		}
+		strData[k] = strValues
+	}
+	return strData
+}
+
+// DataBytes returns the values of the header data data as byte slices.
+func DataBytes(data map[string][]string) map[string][][]byte {
+	if data == nil {
+		return nil
+	}
+	byteData := make(map[string][][]byte, len(data))
+	for k, values := range data {
+		byteData[k] = bytesValues(values)
+	}
+	return byteData
+}
+
+// BytesValues returns every value of key as a byte slice, or nil if there is none.
+func (h *Header) BytesValues(key string) [][]byte {
+	return bytesValues(h.Data[key])
+}
+
+// SetBytesValues replaces the values of key with values, as DataTypeBytes.
+func (h *Header) SetBytesValues(key string, values ...[]byte) {
+	strValues := make([]string, len(values))
+	for i, v := range values {
+		strValues[i] = string(v)
+	}
+	h.SetValue(key, strValues...)
+}
+
+func bytesValues(values []string) [][]byte {
+	if values == nil {
+		return nil
+	}
+	byteValues := make([][]byte, len(values))
+	for i, v := range values {
+		byteValues[i] = []byte(v)
+	}
+	return byteValues
+}
+
+// headerItemJSON is the JSON and YAML form of a HeaderItem.
+// Names, keys and values that are not valid UTF-8 would be mangled by both encodings,
+// so the name is moved to NameBase64 and data entries to DataBase64, base64 encoded.
+type headerItemJSON struct {
+	Name       string              `json:"name" yaml:"name"`
+	NameBase64 string              `json:"name_base64,omitempty" yaml:"name_base64,omitempty"`
+	Size       uint64              `json:"size" yaml:"size"`
+	Data       map[string][]string `json:"data,omitempty" yaml:"data,omitempty"`
+	DataBase64 map[string][]string `json:"data_base64,omitempty" yaml:"data_base64,omitempty"`
+	Start      int64               `json:"start" yaml:"start"`
+	End        int64               `json:"end" yaml:"end"`
+}
+
+func newHeaderItemJSON(hi *HeaderItem) headerItemJSON {
+	j := headerItemJSON{
+		Name:  hi.Name,
+		Size:  hi.Size,
+		Start: hi.Start,
+		End:   hi.End,
+	}
+	if !utf8.ValidString(hi.Name) {
+		j.Name = ""
+		j.NameBase64 = base64.StdEncoding.EncodeToString([]byte(hi.Name))
+	}
+
+	for k, values := range hi.Data {
+		if isText(k, values) {
+			if j.Data == nil {
+				j.Data = make(map[string][]string)
+			}
+			j.Data[k] = values
+			continue
+		}
+
+		encoded := make([]string, len(values))
+		for i, v := range values {
+			encoded[i] = base64.StdEncoding.EncodeToString([]byte(v))
+		}
+		if j.DataBase64 == nil {
+			j.DataBase64 = make(map[string][]string)
+		}
+		j.DataBase64[base64.StdEncoding.EncodeToString([]byte(k))] = encoded
+	}
+
+	return j
+}
+
+func (j *headerItemJSON) headerItem() (*HeaderItem, error) {
+	hi := HeaderItem{
+		Name:  j.Name,
+		Size:  j.Size,
+		Data:  j.Data,
+		Start: j.Start,
+		End:   j.End,
+	}
+	if j.NameBase64 != "" {
+		name, err := base64.StdEncoding.DecodeString(j.NameBase64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name_base64: %w", err)
+		}
+		hi.Name = string(name)
+	}
+
+	for encodedKey, encoded := range j.DataBase64 {
+		k, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data_base64 key %q: %w", encodedKey, err)
+		}
+
+		values := make([]string, len(encoded))
+		for i, e := range encoded {
+			v, err := base64.StdEncoding.DecodeString(e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid data_base64 value of %q: %w", k, err)
+			}
+			values[i] = string(v)
+		}
+		if hi.Data == nil {
+			hi.Data = make(map[string][]string)
+		}
+		hi.Data[string(k)] = values
+	}
+
+	return &hi, nil
+}
+
+// MarshalJSON implements json.Marshaler.
+// Data entries whose key or values are not valid UTF-8 are stored base64 encoded under "data_base64",
+// and so is a name that is not valid UTF-8, under "name_base64".
+func (hi HeaderItem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newHeaderItemJSON(&hi))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (hi *HeaderItem) UnmarshalJSON(b []byte) error {
+	var j headerItemJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	decoded, err := j.headerItem()
+	if err != nil {
+		return err
+	}
+	*hi = *decoded
+	return nil
+}
+
+// MarshalYAML implements the Marshaler interface of the common YAML packages,
+// with the same layout as MarshalJSON.
+func (hi HeaderItem) MarshalYAML() (any, error) {
+	return newHeaderItemJSON(&hi), nil
+}
+
+// UnmarshalYAML implements the Unmarshaler interface of gopkg.in/yaml.v2,
+// which gopkg.in/yaml.v3 supports as well.
+func (hi *HeaderItem) UnmarshalYAML(unmarshal func(any) error) error {
+	var j headerItemJSON
+	if err := unmarshal(&j); err != nil {
+		return err
+	}
+	decoded, err := j.headerItem()
+	if err != nil {
+		return err
+	}
+	*hi = *decoded
+	return nil
+}
+
+// isText reports whether a data entry survives text encodings unchanged.
+func isText(k string, values []string) bool {
+	if !utf8.ValidString(k) {
+		return false
+	}
+	for _, v := range values {
+		if !utf8.ValidString(v) {
+			return false
+		}
+	}
+	return true
+}
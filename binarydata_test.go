@@ -0,0 +1,72 @@
+package pitch
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestDataBytes(t *testing.T) {
+	var (
+		is   = is.New(t)
+		data = map[string][][]byte{
+			"Thumbnail":          {{0x89, 'P', 'N', 'G', 0, 0xff}},
+			string([]byte{0xfe}): {[]byte("a"), {}},
+		}
+		hdr = Header{Name: "a.png", Data: DataFromBytes(data)}
+	)
+
+	decoded, err := DecodeHeader(bytes.NewReader(EncodeHeader(hdr)))
+	is.NoErr(err)
+	is.Equal(DataBytes(decoded.Data), data)
+	is.Equal(decoded.BytesValues("Thumbnail"), data["Thumbnail"])
+	is.Equal(decoded.BytesValues("Missing"), nil)
+
+	hdr.SetBytesValues("Hashes", []byte{1}, []byte{2})
+	is.Equal(hdr.BytesValues("Hashes"), [][]byte{{1}, {2}})
+}
+
+func TestHeaderItem_JSON(t *testing.T) {
+	is := is.New(t)
+
+	t.Run("text", func(t *testing.T) {
+		is := is.New(t)
+		item := HeaderItem{Name: "a.txt", Size: 3, Data: map[string][]string{"Owner": {"team"}}, Start: 10, End: 13}
+
+		b, err := json.Marshal(&item)
+		is.NoErr(err)
+		// the layout is the one given by the struct tags
+		is.Equal(string(b), `{"name":"a.txt","size":3,"data":{"Owner":["team"]},"start":10,"end":13}`)
+
+		var decoded HeaderItem
+		is.NoErr(json.Unmarshal(b, &decoded))
+		is.Equal(decoded, item)
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		is := is.New(t)
+		toc := TableOfContents{
+			"a.png": {
+				Name: string([]byte{'a', 0xff}),
+				Size: 1,
+				Data: map[string][]string{
+					"Owner":                 {"team"},
+					"Hash":                  {string([]byte{0, 0xc3, 0x28})},
+					string([]byte{0xfe, 1}): {"text"},
+				},
+			},
+		}
+
+		b, err := json.Marshal(toc)
+		is.NoErr(err)
+
+		var decoded TableOfContents
+		is.NoErr(json.Unmarshal(b, &decoded))
+		is.Equal(decoded, toc)
+	})
+
+	var item HeaderItem
+	is.True(json.Unmarshal([]byte(`{"name":"a","data_base64":{"!":["a"]}}`), &item) != nil)
+}
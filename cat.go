@@ -1,11 +1,13 @@
 package pitch
 
 import (
+	"context"
 	"errors"
 	"io"
 )
 
 type catReader struct {
+	first         Reader
 	r             Reader
 	readers       []Reader
 	contentReader io.LimitedReader
@@ -13,6 +15,10 @@ type catReader struct {
 }
 
 func (mr *catReader) Next() (*Header, error) {
+	return mr.NextContext(context.Background())
+}
+
+func (mr *catReader) NextContext(ctx context.Context) (*Header, error) {
 	if mr.r == nil {
 		return nil, io.EOF
 	}
@@ -22,19 +28,31 @@ func (mr *catReader) Next() (*Header, error) {
 		return nil, errors.New("unrecognized reader")
 	}
 
-	hdr, e := r.Next()
+	hdr, e := NextContext(ctx, r)
 	if errors.Is(e, io.EOF) {
 		if len(mr.readers) == 0 {
 			return nil, io.EOF
 		}
 		mr.r = mr.readers[0]
 		mr.readers = mr.readers[1:]
-		return mr.Next()
+		return mr.NextContext(ctx)
 	}
 
 	return hdr, e
 }
 
+// ArchiveData returns the archive data of the first archive.
+func (mr *catReader) ArchiveData() map[string][]string {
+	return ArchiveData(mr.first)
+}
+
+func (mr *catReader) skipped() int64 {
+	if s, ok := mr.r.(skipper); ok {
+		return s.skipped()
+	}
+	return 0
+}
+
 func (mr *catReader) Read(b []byte) (int, error) {
 	if mr.r == nil {
 		return 0, io.EOF
@@ -105,6 +123,7 @@ func Cat(r ...Reader) Reader {
 	)
 	copy(readers, r)
 	return &catReader{
+		first:   readers[0],
 		r:       readers[0],
 		readers: readers[1:],
 		offset:  offset,
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/raphaelreyna/pitch"
+)
+
+// runConvert converts between pitch archives and tar or zip archives;
+// the direction is picked from the file extensions.
+func runConvert(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: pitch convert SRC DST")
+	}
+	var (
+		src, dst       = args[0], args[1]
+		srcExt, dstExt = archiveExt(src), archiveExt(dst)
+		convert        func(in *os.File, size int64, out io.Writer) error
+	)
+
+	switch {
+	case srcExt == ".tar" && dstExt == "":
+		convert = func(in *os.File, _ int64, out io.Writer) error {
+			pw := pitch.NewWriter(out)
+			if err := pitch.FromTarContext(ctx, in, pw); err != nil {
+				return err
+			}
+			return pw.Close()
+		}
+	case srcExt == "" && dstExt == ".tar":
+		convert = func(in *os.File, _ int64, out io.Writer) error {
+			// reading the file directly lets references to earlier content be resolved
+			return pitch.ToTarContext(ctx, pitch.NewReader(in), out)
+		}
+	case srcExt == ".zip" && dstExt == "":
+		convert = func(in *os.File, size int64, out io.Writer) error {
+			pw := pitch.NewWriter(out)
+			if err := pitch.FromZipContext(ctx, in, size, pw); err != nil {
+				return err
+			}
+			return pw.Close()
+		}
+	case srcExt == "" && dstExt == ".zip":
+		convert = func(in *os.File, size int64, out io.Writer) error {
+			return pitch.ToZipContext(ctx, in, size, out)
+		}
+	default:
+		return fmt.Errorf("cannot convert %s to %s: exactly one of them must be a .tar or .zip file", src, dst)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := convert(in, info.Size(), out); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// archiveExt returns ".tar" or ".zip" for files in those formats and "" for anything else.
+func archiveExt(name string) string {
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".tar", ".zip":
+		return ext
+	}
+	return ""
+}
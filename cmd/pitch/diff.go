@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/raphaelreyna/pitch"
+)
+
+// runDiff prints the entries that differ between two archives, or between an archive and a directory.
+// It fails if any entry differs, like diff and tar --diff do.
+func runDiff(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var (
+		flags    = flag.NewFlagSet("pitch diff", flag.ContinueOnError)
+		contents = flags.Bool("content", false, "compare the contents of files whose sizes match")
+		ignore   = flags.String("ignore", "", "do not compare the comma separated header data `keys`")
+	)
+	flags.SetOutput(stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("usage: pitch diff [-content] [-ignore keys] ARCHIVE ARCHIVE|DIR")
+	}
+
+	opts := pitch.DiffOptions{Contents: *contents}
+	if *ignore != "" {
+		opts.IgnoreData = strings.Split(*ignore, ",")
+	}
+
+	ra, a, err := openTableOfContents(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer ra.Close()
+
+	var changes []pitch.Change
+	if info, err := os.Stat(flags.Arg(1)); err == nil && info.IsDir() {
+		changes, err = pitch.DiffDir(ctx, ra, a, flags.Arg(1), &opts)
+		if err != nil {
+			return err
+		}
+	} else {
+		rb, b, err := openTableOfContents(ctx, flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer rb.Close()
+
+		changes, err = pitch.DiffArchives(ctx, ra, a, rb, b, &opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, c := range changes {
+		printChange(stdout, &c)
+	}
+	if 0 < len(changes) {
+		return fmt.Errorf("%d entries differ", len(changes))
+	}
+	return nil
+}
+
+// openTableOfContents opens the archive at name and builds its table of contents.
+// Empty archives have empty tables of contents.
+func openTableOfContents(ctx context.Context, name string) (*os.File, pitch.TableOfContents, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	toc, err := pitch.BuildTableOfContentsContext(ctx, f)
+	if errors.Is(err, io.EOF) {
+		toc, err = pitch.TableOfContents{}, nil
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, toc, nil
+}
+
+// printChange prints c as "A name" for added entries, "D name" for removed ones
+// and "M name: changes" for the others.
+func printChange(w io.Writer, c *pitch.Change) {
+	switch {
+	case c.Kind&pitch.ChangeAdded != 0:
+		fmt.Fprintf(w, "A %s\n", c.Name)
+		return
+	case c.Kind&pitch.ChangeRemoved != 0:
+		fmt.Fprintf(w, "D %s\n", c.Name)
+		return
+	}
+
+	var details []string
+	if c.Kind&pitch.ChangeSize != 0 {
+		details = append(details, fmt.Sprintf("size %d -> %d", c.Old.Size, c.New.Size))
+	}
+	if c.Kind&pitch.ChangeContent != 0 {
+		details = append(details, "content")
+	}
+	if c.Kind&pitch.ChangeData != 0 {
+		details = append(details, "data "+strings.Join(c.DataKeys, " "))
+	}
+	fmt.Fprintf(w, "M %s: %s\n", c.Name, strings.Join(details, ", "))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestRun_Diff(t *testing.T) {
+	var (
+		is     = is.New(t)
+		dir    = t.TempDir()
+		oldPch = filepath.Join(dir, "old.pch")
+		newPch = filepath.Join(dir, "new.pch")
+		stdout = bytes.NewBuffer(nil)
+	)
+
+	is.NoErr(os.MkdirAll(filepath.Join(dir, "src"), 0o755))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "src", "a.txt"), []byte("AAA"), 0o644))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "src", "b.txt"), []byte("BBB"), 0o644))
+	err := run(context.Background(), []string{"-c", "-f", oldPch, "-C", dir, "src"}, nil, stdout, stdout)
+	is.NoErr(err)
+
+	err = run(context.Background(), []string{"diff", "-content", oldPch, oldPch}, nil, stdout, stdout)
+	is.NoErr(err)
+	is.Equal(stdout.String(), "")
+
+	is.NoErr(os.WriteFile(filepath.Join(dir, "src", "a.txt"), []byte("AAAA"), 0o644))
+	is.NoErr(os.Remove(filepath.Join(dir, "src", "b.txt")))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "src", "c.txt"), []byte("CCC"), 0o644))
+	err = run(context.Background(), []string{"-c", "-f", newPch, "-C", dir, "src"}, nil, stdout, stdout)
+	is.NoErr(err)
+
+	err = run(context.Background(), []string{"diff", "-ignore", "Pitch-Mod-Time", oldPch, newPch}, nil, stdout, stdout)
+	is.True(err != nil) // entries differ
+	is.Equal(stdout.String(), "M src/a.txt: size 3 -> 4\nD src/b.txt\nA src/c.txt\n")
+
+	stdout.Reset()
+	err = run(context.Background(), []string{"diff", "-ignore", "Pitch-Mod-Time", oldPch, filepath.Join(dir, "src")}, nil, stdout, stdout)
+	is.True(err != nil)
+	is.Equal(stdout.String(), "M src/a.txt: size 3 -> 4\nD src/b.txt\n")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/raphaelreyna/pitch"
+)
+
+// runFind prints the entries of an archive whose headers match a query.
+// Only headers are read; the content of every entry is skipped.
+func runFind(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var (
+		flags   = flag.NewFlagSet("pitch find", flag.ContinueOnError)
+		verbose = flags.Bool("v", false, "list the details of every entry found")
+	)
+	flags.SetOutput(stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		return errors.New("usage: pitch find [-v] ARCHIVE QUERY...")
+	}
+
+	q, err := pitch.ParseQuery(strings.Join(flags.Args()[1:], " "))
+	if err != nil {
+		return err
+	}
+
+	var src = stdin
+	if name := flags.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		src = f
+	}
+
+	r := pitch.NewReader(src)
+	for {
+		hdr, err := pitch.NextContext(ctx, r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if q.Match(hdr) {
+			printEntry(stdout, hdr, *verbose)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestRun_Find(t *testing.T) {
+	var (
+		is      = is.New(t)
+		dir     = t.TempDir()
+		archive = filepath.Join(dir, "src.pch")
+		stdout  = bytes.NewBuffer(nil)
+	)
+
+	is.NoErr(os.MkdirAll(filepath.Join(dir, "src", "sub"), 0o755))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "src", "a.txt"), []byte("AAA"), 0o644))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "src", "sub", "b.txt"), []byte("BBBBBB"), 0o644))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "src", "sub", "c.go"), []byte("package c"), 0o644))
+
+	err := run(context.Background(), []string{"-c", "-f", archive, "-C", dir, "src"}, nil, stdout, stdout)
+	is.NoErr(err)
+
+	stdout.Reset()
+	err = run(context.Background(), []string{"find", archive, "name~*.txt", "size>3"}, nil, stdout, stdout)
+	is.NoErr(err)
+	is.Equal(stdout.String(), "src/sub/b.txt\n")
+
+	stdout.Reset()
+	err = run(context.Background(), []string{"find", archive, "name~src/sub/* and not name~*.txt"}, nil, stdout, stdout)
+	is.NoErr(err)
+	is.Equal(stdout.String(), "src/sub/c.go\n")
+
+	err = run(context.Background(), []string{"find", archive, "size~1"}, nil, stdout, stdout)
+	is.True(err != nil)
+}
@@ -0,0 +1,11 @@
+module github.com/raphaelreyna/pitch/cmd/pitch
+
+go 1.25.0
+
+require (
+	github.com/matryer/is v1.4.0
+	github.com/raphaelreyna/pitch v0.0.0
+	golang.org/x/net v0.57.0
+)
+
+replace github.com/raphaelreyna/pitch => ../..
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/raphaelreyna/pitch"
+)
+
+// runIndex writes the sidecar of an archive, ARCHIVE.idx unless -o says otherwise,
+// or checks that an existing sidecar still matches its archive.
+func runIndex(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var (
+		flags  = flag.NewFlagSet("pitch index", flag.ContinueOnError)
+		out    = flags.String("o", "", "write the sidecar to `file` instead of ARCHIVE"+pitch.SidecarExt)
+		asJSON = flags.Bool("json", false, "write the sidecar as JSON")
+		check  = flags.Bool("check", false, "check the sidecar against the archive instead of writing it")
+		full   = flags.Bool("full", false, "with -check, hash the whole archive rather than its size and last 64 KiB")
+	)
+	flags.SetOutput(stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: pitch index [-json] [-check [-full]] [-o file] ARCHIVE")
+	}
+
+	name := flags.Arg(0)
+	if *out == "" {
+		*out = name + pitch.SidecarExt
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if *check {
+		sf, err := os.Open(*out)
+		if err != nil {
+			return err
+		}
+		defer sf.Close()
+
+		s, err := pitch.ReadSidecar(sf)
+		if err != nil {
+			return err
+		}
+		verify := s.Verify
+		if *full {
+			verify = s.VerifyDigest
+		}
+		if err := verify(f, info.Size()); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s: ok, %d entries\n", *out, len(s.TableOfContents))
+		return nil
+	}
+
+	s, err := pitch.NewSidecarContext(ctx, f, info.Size())
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		err = json.NewEncoder(dst).Encode(s)
+	} else {
+		_, err = s.WriteTo(dst)
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/raphaelreyna/pitch"
+)
+
+func TestRun_Index(t *testing.T) {
+	var (
+		is      = is.New(t)
+		dir     = t.TempDir()
+		archive = filepath.Join(dir, "src.pch")
+		stdout  = bytes.NewBuffer(nil)
+	)
+
+	is.NoErr(os.MkdirAll(filepath.Join(dir, "src"), 0o755))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "src", "a.txt"), []byte("AAA"), 0o644))
+
+	err := run(context.Background(), []string{"-c", "-f", archive, "-C", dir, "src"}, nil, stdout, stdout)
+	is.NoErr(err)
+
+	err = run(context.Background(), []string{"index", archive}, nil, stdout, stdout)
+	is.NoErr(err)
+	sf, err := os.Open(archive + pitch.SidecarExt)
+	is.NoErr(err)
+	defer sf.Close()
+	s, err := pitch.ReadSidecar(sf)
+	is.NoErr(err)
+	is.True(s.TableOfContents["src/a.txt"] != nil)
+
+	stdout.Reset()
+	err = run(context.Background(), []string{"index", "-check", archive}, nil, stdout, stdout)
+	is.NoErr(err)
+	is.Equal(stdout.String(), archive+pitch.SidecarExt+": ok, 1 entries\n")
+
+	jsonSidecar := filepath.Join(dir, "src.json")
+	err = run(context.Background(), []string{"index", "-json", "-o", jsonSidecar, archive}, nil, stdout, stdout)
+	is.NoErr(err)
+	err = run(context.Background(), []string{"index", "-check", "-o", jsonSidecar, archive}, nil, stdout, stdout)
+	is.NoErr(err)
+
+	// the archive changes under the sidecar
+	f, err := os.OpenFile(archive, os.O_APPEND|os.O_WRONLY, 0)
+	is.NoErr(err)
+	_, err = f.Write([]byte{0})
+	is.NoErr(err)
+	is.NoErr(f.Close())
+	err = run(context.Background(), []string{"index", "-check", archive}, nil, stdout, stdout)
+	is.True(err != nil)
+	err = run(context.Background(), []string{"index", "-check", "-full", archive}, nil, stdout, stdout)
+	is.True(err != nil)
+}
+
+func TestRun_IndexArchiveData(t *testing.T) {
+	var (
+		is      = is.New(t)
+		archive = filepath.Join(t.TempDir(), "data.pch")
+		stdout  = bytes.NewBuffer(nil)
+	)
+
+	f, err := os.Create(archive)
+	is.NoErr(err)
+	w := pitch.NewWriter(f)
+	is.NoErr(w.SetArchiveData(map[string][]string{"Creator": {"test"}}))
+	_, err = w.WriteHeader("a.txt", 3, nil)
+	is.NoErr(err)
+	_, err = w.Write([]byte("AAA"))
+	is.NoErr(err)
+	is.NoErr(w.Close())
+	is.NoErr(f.Close())
+
+	err = run(context.Background(), []string{"index", archive}, nil, stdout, stdout)
+	is.NoErr(err)
+
+	// the archive data is not an entry
+	stdout.Reset()
+	err = run(context.Background(), []string{"index", "-check", archive}, nil, stdout, stdout)
+	is.NoErr(err)
+	is.Equal(stdout.String(), archive+pitch.SidecarExt+": ok, 1 entries\n")
+}
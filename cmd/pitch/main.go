@@ -0,0 +1,343 @@
+// Command pitch creates, lists and extracts pitch archives.
+// Its flags follow the tar command as closely as possible.
+//
+// The convert subcommand converts between pitch archives and tar or zip archives:
+//
+//	pitch convert archive.tar archive.pch
+//	pitch convert archive.pch archive.zip
+//
+// The serve-webdav subcommand gives read-only WebDAV access to the contents of an archive:
+//
+//	pitch serve-webdav -addr localhost:8080 archive.pch
+//
+// The find subcommand lists the entries whose headers match a query, see pitch.ParseQuery:
+//
+//	pitch find archive.pch 'name~*.html and data.Owner=web'
+//
+// The index subcommand writes a sidecar table of contents next to an archive, see pitch.Sidecar,
+// or checks that one still matches its archive:
+//
+//	pitch index archive.pch
+//	pitch index -check archive.pch
+//
+// The diff subcommand lists the entries that differ between two archives, or between an archive
+// and a directory like tar --diff, see pitch.Diff:
+//
+//	pitch diff -content old.pch new.pch
+//	pitch diff -ignore Pitch-Mod-Time archive.pch .
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+
+	"github.com/raphaelreyna/pitch"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "pitch: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// options holds the flags shared by every mode.
+type options struct {
+	file     string
+	dir      string
+	follow   bool
+	verbose  bool
+	progress bool
+	dedup    bool
+	sparse   bool
+	jobs     int
+
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if 0 < len(args) {
+		switch args[0] {
+		case "convert":
+			return runConvert(ctx, args[1:])
+		case "serve-webdav":
+			return runServeWebDAV(ctx, args[1:], stderr)
+		case "find":
+			return runFind(ctx, args[1:], stdin, stdout, stderr)
+		case "index":
+			return runIndex(ctx, args[1:], stdout, stderr)
+		case "diff":
+			return runDiff(ctx, args[1:], stdout, stderr)
+		}
+	}
+
+	var (
+		flags = flag.NewFlagSet("pitch", flag.ContinueOnError)
+		opts  = options{
+			stdin:  stdin,
+			stdout: stdout,
+			stderr: stderr,
+		}
+
+		create  = flags.Bool("c", false, "create a new archive")
+		extract = flags.Bool("x", false, "extract files from an archive")
+		list    = flags.Bool("t", false, "list the contents of an archive")
+	)
+	flags.SetOutput(stderr)
+	flags.StringVar(&opts.file, "f", "-", "use archive `file`, - for stdin or stdout")
+	flags.StringVar(&opts.dir, "C", "", "change to `dir` before creating or extracting")
+	flags.BoolVar(&opts.follow, "h", false, "follow symlinks and archive the files they point to")
+	flags.BoolVar(&opts.verbose, "v", false, "list entries as they are processed")
+	flags.BoolVar(&opts.progress, "progress", false, "show a progress bar on stderr")
+	flags.BoolVar(&opts.dedup, "dedup", false, "store files with identical contents only once")
+	flags.BoolVar(&opts.sparse, "S", false, "store only the data of sparse files, not their holes")
+	flags.IntVar(&opts.jobs, "jobs", runtime.GOMAXPROCS(0), "number of files read or extracted at once")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var modes int
+	for _, set := range []bool{*create, *extract, *list} {
+		if set {
+			modes++
+		}
+	}
+	if modes != 1 {
+		flags.Usage()
+		return errors.New("exactly one of -c, -x or -t is required")
+	}
+
+	switch {
+	case *create:
+		return runCreate(ctx, &opts, flags.Args())
+	case *extract:
+		return runExtract(ctx, &opts)
+	default:
+		return runList(ctx, &opts)
+	}
+}
+
+func runCreate(ctx context.Context, opts *options, paths []string) error {
+	if len(paths) == 0 {
+		return errors.New("refusing to create an empty archive")
+	}
+
+	for i, p := range paths {
+		if opts.dir != "" && !filepath.IsAbs(p) {
+			paths[i] = filepath.Join(opts.dir, p)
+		}
+	}
+
+	var (
+		dst     io.WriteCloser = nopCloser{opts.stdout}
+		verbose                = opts.stdout
+	)
+	if opts.file != "-" {
+		f, err := os.Create(opts.file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dst = f
+	} else {
+		// the archive itself goes to stdout
+		verbose = opts.stderr
+	}
+
+	var bar *progressBar
+	if opts.progress {
+		bar = newProgressBar(opts.stderr)
+		for _, p := range paths {
+			entries, size, err := treeSize(p)
+			if err != nil {
+				return err
+			}
+			bar.totalEntries += entries
+			bar.totalBytes += size
+		}
+	}
+
+	archiveOpts := pitch.ArchiveOptions{
+		Symlinks:    pitch.SymlinkStore,
+		Metadata:    true,
+		Hardlinks:   true,
+		Sparse:      opts.sparse,
+		Dedup:       opts.dedup,
+		Concurrency: opts.jobs,
+		Observer:    newObserver(opts, verbose, bar),
+	}
+	if opts.follow {
+		archiveOpts.Symlinks = pitch.SymlinkFollowOrStore
+	}
+
+	// a single writer lets hard links and references point into any of the trees
+	if err := pitch.ArchiveDirsContext(ctx, dst, paths, &archiveOpts); err != nil {
+		return err
+	}
+	bar.finish()
+
+	return dst.Close()
+}
+
+func runExtract(ctx context.Context, opts *options) error {
+	var (
+		dst = opts.dir
+		bar *progressBar
+	)
+	if dst == "" {
+		dst = "."
+	}
+	if opts.progress {
+		bar = newProgressBar(opts.stderr)
+	}
+
+	extractOpts := pitch.ExtractOptions{
+		Concurrency: opts.jobs,
+		Observer:    newObserver(opts, opts.stdout, bar),
+	}
+
+	if opts.file == "-" {
+		err := pitch.ExtractContext(ctx, dst, pitch.NewReader(opts.stdin), &extractOpts)
+		bar.finish()
+		return err
+	}
+
+	f, err := os.Open(opts.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// with a file on disk the table of contents is cheap to build and lets entries be extracted in parallel
+	toc, err := pitch.BuildTableOfContentsContext(ctx, f)
+	if err != nil {
+		return err
+	}
+	if bar != nil {
+		// in archive order, so hard links come after the entries they link to
+		for name, item := range toc.ByLocation() {
+			bar.addTotal(name, item.Size, item.Data)
+		}
+	}
+
+	err = pitch.ExtractAt(ctx, dst, f, toc, &extractOpts)
+	bar.finish()
+	return err
+}
+
+func runList(ctx context.Context, opts *options) error {
+	var src = opts.stdin
+	if opts.file != "-" {
+		f, err := os.Open(opts.file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		src = f
+	}
+
+	r := pitch.NewReader(src)
+	for {
+		hdr, err := pitch.NextContext(ctx, r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		printEntry(opts.stdout, hdr, opts.verbose)
+	}
+}
+
+// printEntry prints the name of the entry described by hdr, along with its details if verbose is set.
+func printEntry(w io.Writer, hdr *pitch.Header, verbose bool) {
+	if !verbose {
+		fmt.Fprintln(w, hdr.Name)
+		return
+	}
+
+	var (
+		mode    = dataValue(hdr.Data, pitch.DataKeyMode, "----")
+		modTime = dataValue(hdr.Data, pitch.DataKeyModTime, "-")
+		name    = hdr.Name
+	)
+	switch pitch.EntryType(hdr.Data) {
+	case pitch.EntryTypeSymlink:
+		name += " -> " + dataValue(hdr.Data, pitch.DataKeyLinkTarget, "")
+	case pitch.EntryTypeHardlink:
+		name += " link to " + dataValue(hdr.Data, pitch.DataKeyLinkTarget, "")
+	}
+	fmt.Fprintf(w, "%s %12d %s %s\n", mode, hdr.Size, modTime, name)
+}
+
+// newObserver returns the observer reporting to the user, or nil if nothing is reported.
+func newObserver(opts *options, verbose io.Writer, bar *progressBar) pitch.Observer {
+	var observers multiObserver
+	if bar != nil {
+		observers = append(observers, bar)
+	}
+	if opts.verbose {
+		observers = append(observers, &verboseObserver{
+			w:   verbose,
+			bar: bar,
+		})
+	}
+
+	switch len(observers) {
+	case 0:
+		return nil
+	case 1:
+		return observers[0]
+	}
+	return observers
+}
+
+// treeSize returns the number of files under p and their total size.
+func treeSize(p string) (entries int, size int64, err error) {
+	err = filepath.WalkDir(p, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		entries++
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+func dataValue(data map[string][]string, key, fallback string) string {
+	if v := data[key]; 0 < len(v) {
+		return v[0]
+	}
+	return fallback
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestRun_CreateListExtract(t *testing.T) {
+	var (
+		is      = is.New(t)
+		dir     = t.TempDir()
+		archive = filepath.Join(dir, "src.pch")
+		stdout  = bytes.NewBuffer(nil)
+		stderr  = bytes.NewBuffer(nil)
+	)
+
+	err := os.MkdirAll(filepath.Join(dir, "src", "sub"), 0o755)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(dir, "src", "a.txt"), []byte("AAA"), 0o644)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(dir, "src", "sub", "b.txt"), []byte("BBB"), 0o600)
+	is.NoErr(err)
+
+	err = run(context.Background(), []string{"-c", "--progress", "-f", archive, "-C", dir, "src"}, nil, stdout, stderr)
+	is.NoErr(err)
+	is.True(strings.Contains(stderr.String(), "2/2 files"))
+
+	stdout.Reset()
+	err = run(context.Background(), []string{"-t", "-f", archive}, nil, stdout, stderr)
+	is.NoErr(err)
+	is.Equal(stdout.String(), "src/a.txt\nsrc/sub/b.txt\n")
+
+	out := filepath.Join(dir, "out")
+	err = run(context.Background(), []string{"-x", "-f", archive, "-C", out}, nil, stdout, stderr)
+	is.NoErr(err)
+
+	contents, err := os.ReadFile(filepath.Join(out, "src", "sub", "b.txt"))
+	is.NoErr(err)
+	is.Equal(string(contents), "BBB")
+
+	info, err := os.Stat(filepath.Join(out, "src", "sub", "b.txt"))
+	is.NoErr(err)
+	is.Equal(info.Mode().Perm(), os.FileMode(0o600))
+}
+
+func TestRun_CreateDedupDirs(t *testing.T) {
+	var (
+		is      = is.New(t)
+		dir     = t.TempDir()
+		archive = filepath.Join(dir, "both.pch")
+		files   = map[string]string{
+			"one/a.txt": "same content",
+			"two/b.txt": "same content",
+			"two/c.txt": "other content",
+			"two/d.txt": "other content",
+		}
+	)
+
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		is.NoErr(os.MkdirAll(filepath.Dir(p), 0o755))
+		is.NoErr(os.WriteFile(p, []byte(content), 0o644))
+	}
+
+	err := run(context.Background(), []string{"-c", "--dedup", "-f", archive, "-C", dir, "one", "two"}, nil, bytes.NewBuffer(nil), bytes.NewBuffer(nil))
+	is.NoErr(err)
+
+	// the archive is extracted from the file, with a table of contents, and streamed through stdin
+	f, err := os.Open(archive)
+	is.NoErr(err)
+	defer f.Close()
+
+	for _, args := range [][]string{{"-f", archive}, {"-f", "-"}} {
+		out := t.TempDir()
+		err = run(context.Background(), append([]string{"-x", "-C", out}, args...), f, bytes.NewBuffer(nil), bytes.NewBuffer(nil))
+		is.NoErr(err)
+
+		for name, content := range files {
+			got, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+			is.NoErr(err)
+			is.Equal(string(got), content)
+		}
+	}
+}
+
+func TestRun_ProgressLogicalSizes(t *testing.T) {
+	var (
+		is      = is.New(t)
+		dir     = t.TempDir()
+		archive = filepath.Join(dir, "src.pch")
+		content = bytes.Repeat([]byte("pitch"), 1000)
+	)
+
+	is.NoErr(os.MkdirAll(filepath.Join(dir, "src"), 0o755))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "src", "a.txt"), content, 0o644))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "src", "b.txt"), content, 0o644))
+	is.NoErr(os.Link(filepath.Join(dir, "src", "a.txt"), filepath.Join(dir, "src", "c.txt")))
+
+	// b.txt is stored as a reference and c.txt as a hard link, but both count in full
+	stderr := bytes.NewBuffer(nil)
+	err := run(context.Background(), []string{"-c", "--progress", "--dedup", "-f", archive, "-C", dir, "src"}, nil, bytes.NewBuffer(nil), stderr)
+	is.NoErr(err)
+	is.True(strings.Contains(stderr.String(), "100% "))
+	is.True(strings.Contains(stderr.String(), "3/3 files"))
+
+	stderr.Reset()
+	err = run(context.Background(), []string{"-x", "--progress", "-f", archive, "-C", t.TempDir()}, nil, bytes.NewBuffer(nil), stderr)
+	is.NoErr(err)
+	is.True(strings.Contains(stderr.String(), "100% "))
+	is.True(strings.Contains(stderr.String(), "3/3 files"))
+}
+
+func TestRun_Modes(t *testing.T) {
+	var is = is.New(t)
+
+	err := run(context.Background(), []string{"-c", "-x"}, nil, bytes.NewBuffer(nil), bytes.NewBuffer(nil))
+	is.True(err != nil)
+
+	err = run(context.Background(), []string{"-f", "x.pch"}, nil, bytes.NewBuffer(nil), bytes.NewBuffer(nil))
+	is.True(err != nil)
+}
+
+func TestRun_Convert(t *testing.T) {
+	var (
+		is      = is.New(t)
+		dir     = t.TempDir()
+		archive = filepath.Join(dir, "src.pch")
+		tarball = filepath.Join(dir, "src.tar")
+		zipfile = filepath.Join(dir, "src.zip")
+		back    = filepath.Join(dir, "back.pch")
+		stdout  = bytes.NewBuffer(nil)
+	)
+
+	err := os.MkdirAll(filepath.Join(dir, "src"), 0o755)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(dir, "src", "a.txt"), []byte("AAA"), 0o644)
+	is.NoErr(err)
+
+	err = run(context.Background(), []string{"-c", "-f", archive, "-C", dir, "src"}, nil, stdout, stdout)
+	is.NoErr(err)
+
+	err = run(context.Background(), []string{"convert", archive, tarball}, nil, stdout, stdout)
+	is.NoErr(err)
+	err = run(context.Background(), []string{"convert", tarball, back}, nil, stdout, stdout)
+	is.NoErr(err)
+	err = run(context.Background(), []string{"convert", back, zipfile}, nil, stdout, stdout)
+	is.NoErr(err)
+	err = run(context.Background(), []string{"convert", zipfile, back}, nil, stdout, stdout)
+	is.NoErr(err)
+
+	stdout.Reset()
+	err = run(context.Background(), []string{"-t", "-f", back}, nil, stdout, stdout)
+	is.NoErr(err)
+	is.Equal(stdout.String(), "src/a.txt\n")
+
+	err = run(context.Background(), []string{"convert", archive, back}, nil, stdout, stdout)
+	is.True(err != nil)
+}
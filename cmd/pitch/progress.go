@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raphaelreyna/pitch"
+)
+
+const (
+	progressBarWidth    = 30
+	progressRenderEvery = 100 * time.Millisecond
+)
+
+// progressBar renders a single line progress bar with throughput and ETA.
+// Its totals are logical file sizes, so entries stored in fewer bytes than they hold
+// (sparse files, references and hard links) count their logical size once they are done.
+// A nil *progressBar is valid and renders nothing.
+type progressBar struct {
+	w   io.Writer
+	now func() time.Time
+
+	totalEntries int
+	totalBytes   int64
+	entries      int
+	bytes        int64
+	name         string
+
+	// reported holds the bytes reported so far for every entry in progress.
+	reported map[*pitch.Header]int64
+	// sizes holds the logical size of every entry done, for hard links to them.
+	sizes map[string]int64
+
+	start    time.Time
+	rendered time.Time
+}
+
+func newProgressBar(w io.Writer) *progressBar {
+	pb := progressBar{
+		w:        w,
+		now:      time.Now,
+		reported: make(map[*pitch.Header]int64),
+		sizes:    make(map[string]int64),
+	}
+	pb.start = pb.now()
+	return &pb
+}
+
+func (pb *progressBar) EntryStart(hdr *pitch.Header) {
+	pb.name = hdr.Name
+	pb.render(false)
+}
+
+func (pb *progressBar) EntryProgress(hdr *pitch.Header, n int64) {
+	pb.bytes += n
+	pb.reported[hdr] += n
+	pb.render(false)
+}
+
+func (pb *progressBar) EntryDone(hdr *pitch.Header, err error) {
+	reported := pb.reported[hdr]
+	delete(pb.reported, hdr)
+
+	if err == nil {
+		size := pb.logicalSize(hdr.Size, hdr.Data)
+		if reported < size {
+			pb.bytes += size - reported
+		}
+		pb.sizes[hdr.Name] = size
+	}
+	pb.entries++
+	pb.render(false)
+}
+
+// addTotal counts an entry that is yet to be processed in the totals.
+// Hard links must be added after the entry they link to.
+func (pb *progressBar) addTotal(name string, size uint64, data map[string][]string) {
+	n := pb.logicalSize(size, data)
+	pb.totalEntries++
+	pb.totalBytes += n
+	pb.sizes[name] = n
+}
+
+// logicalSize returns the size of the file an entry stands for.
+func (pb *progressBar) logicalSize(size uint64, data map[string][]string) int64 {
+	if n, err := strconv.ParseInt(dataValue(data, pitch.DataKeySparseSize, ""), 10, 64); err == nil {
+		return n
+	}
+
+	switch pitch.EntryType(data) {
+	case pitch.EntryTypeRef:
+		if n, err := strconv.ParseInt(dataValue(data, pitch.DataKeyRefSize, ""), 10, 64); err == nil {
+			return n
+		}
+	case pitch.EntryTypeHardlink:
+		return pb.sizes[dataValue(data, pitch.DataKeyLinkTarget, "")]
+	}
+	return int64(size)
+}
+
+// finish renders the final state of the bar and ends its line.
+func (pb *progressBar) finish() {
+	if pb == nil {
+		return
+	}
+	pb.name = ""
+	pb.render(true)
+	fmt.Fprintln(pb.w)
+}
+
+// clear erases the bar so other output can be written on its line.
+func (pb *progressBar) clear() {
+	if pb == nil {
+		return
+	}
+	fmt.Fprint(pb.w, "\r\x1b[K")
+	pb.rendered = time.Time{}
+}
+
+func (pb *progressBar) render(force bool) {
+	now := pb.now()
+	if !force && now.Sub(pb.rendered) < progressRenderEvery {
+		return
+	}
+	pb.rendered = now
+
+	var (
+		line    strings.Builder
+		elapsed = now.Sub(pb.start).Seconds()
+		rate    float64
+	)
+	if 0 < elapsed {
+		rate = float64(pb.bytes) / elapsed
+	}
+
+	line.WriteString("\r\x1b[K")
+	if 0 < pb.totalBytes {
+		fraction := float64(pb.bytes) / float64(pb.totalBytes)
+		if 1 < fraction {
+			fraction = 1
+		}
+		filled := int(fraction * progressBarWidth)
+		line.WriteString("[")
+		line.WriteString(strings.Repeat("=", filled))
+		if filled < progressBarWidth {
+			line.WriteString(">")
+			line.WriteString(strings.Repeat(" ", progressBarWidth-filled-1))
+		}
+		fmt.Fprintf(&line, "] %3.0f%% ", fraction*100)
+	}
+
+	fmt.Fprintf(&line, "%s %s/s", formatBytes(float64(pb.bytes)), formatBytes(rate))
+
+	if 0 < pb.totalBytes && 0 < rate {
+		remaining := time.Duration(float64(pb.totalBytes-pb.bytes) / rate * float64(time.Second))
+		fmt.Fprintf(&line, " ETA %s", formatDuration(remaining))
+	}
+
+	if 0 < pb.totalEntries {
+		fmt.Fprintf(&line, " %d/%d files", pb.entries, pb.totalEntries)
+	} else {
+		fmt.Fprintf(&line, " %d files", pb.entries)
+	}
+
+	if pb.name != "" {
+		line.WriteString(" ")
+		line.WriteString(pb.name)
+	}
+
+	io.WriteString(pb.w, line.String())
+}
+
+func formatBytes(n float64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%.0f B", n)
+	}
+
+	i := -1
+	for 1024 <= n && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", n, units[i])
+}
+
+func formatDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	d = d.Round(time.Second)
+	var (
+		h = d / time.Hour
+		m = (d % time.Hour) / time.Minute
+		s = (d % time.Minute) / time.Second
+	)
+	if 0 < h {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// verboseObserver prints the name of every entry once it is done.
+type verboseObserver struct {
+	w   io.Writer
+	bar *progressBar
+}
+
+func (vo *verboseObserver) EntryStart(hdr *pitch.Header) {}
+
+func (vo *verboseObserver) EntryProgress(hdr *pitch.Header, n int64) {}
+
+func (vo *verboseObserver) EntryDone(hdr *pitch.Header, err error) {
+	vo.bar.clear()
+	if err != nil {
+		fmt.Fprintf(vo.w, "%s: %v\n", hdr.Name, err)
+		return
+	}
+	fmt.Fprintln(vo.w, hdr.Name)
+}
+
+// multiObserver forwards every event to each of its observers in order.
+type multiObserver []pitch.Observer
+
+func (mo multiObserver) EntryStart(hdr *pitch.Header) {
+	for _, o := range mo {
+		o.EntryStart(hdr)
+	}
+}
+
+func (mo multiObserver) EntryProgress(hdr *pitch.Header, n int64) {
+	for _, o := range mo {
+		o.EntryProgress(hdr, n)
+	}
+}
+
+func (mo multiObserver) EntryDone(hdr *pitch.Header, err error) {
+	for _, o := range mo {
+		o.EntryDone(hdr, err)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/raphaelreyna/pitch"
+	"golang.org/x/net/webdav"
+)
+
+// runServeWebDAV serves the contents of an archive over read-only WebDAV until ctx is done.
+func runServeWebDAV(ctx context.Context, args []string, stderr io.Writer) error {
+	var (
+		flags = flag.NewFlagSet("pitch serve-webdav", flag.ContinueOnError)
+		addr  = flags.String("addr", "localhost:8080", "listen on `address`")
+	)
+	flags.SetOutput(stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: pitch serve-webdav [-addr address] ARCHIVE")
+	}
+
+	handler, closer, err := newWebDAVHandler(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "serving %s at http://%s/\n", flags.Arg(0), ln.Addr())
+
+	srv := http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// newWebDAVHandler returns a WebDAV handler for the archive at name and the file backing it.
+func newWebDAVHandler(ctx context.Context, name string) (http.Handler, io.Closer, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	toc, err := pitch.BuildTableOfContentsContext(ctx, f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	fsys, err := pitch.NewFS(f, toc)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return &webdav.Handler{
+		FileSystem: readOnlyFS{fsys},
+		LockSystem: webdav.NewMemLS(),
+	}, f, nil
+}
+
+// readOnlyFS adapts an fs.FS to a webdav.FileSystem that refuses every change.
+type readOnlyFS struct {
+	fsys fs.FS
+}
+
+func (rfs readOnlyFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrPermission}
+}
+
+func (rfs readOnlyFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+
+	f, err := rfs.fsys.Open(fsName(name))
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyFile{f}, nil
+}
+
+func (rfs readOnlyFS) RemoveAll(ctx context.Context, name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+func (rfs readOnlyFS) Rename(ctx context.Context, oldName, newName string) error {
+	return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrPermission}
+}
+
+func (rfs readOnlyFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return fs.Stat(rfs.fsys, fsName(name))
+}
+
+// fsName turns a WebDAV path into an fs.FS name.
+func fsName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// readOnlyFile adapts an fs.File to a webdav.File.
+type readOnlyFile struct {
+	fs.File
+}
+
+func (f *readOnlyFile) Seek(offset int64, whence int) (int64, error) {
+	if s, ok := f.File.(io.Seeker); ok {
+		return s.Seek(offset, whence)
+	}
+	return 0, errors.New("seek not supported")
+}
+
+func (f *readOnlyFile) Readdir(count int) ([]fs.FileInfo, error) {
+	d, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, errors.New("not a directory")
+	}
+
+	entries, err := d.ReadDir(count)
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return infos, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, err
+}
+
+func (f *readOnlyFile) Write([]byte) (int, error) {
+	return 0, fs.ErrPermission
+}
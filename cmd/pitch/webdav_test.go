@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestWebDAV(t *testing.T) {
+	var (
+		is      = is.New(t)
+		dir     = t.TempDir()
+		archive = filepath.Join(dir, "src.pch")
+		out     = bytes.NewBuffer(nil)
+	)
+
+	err := os.MkdirAll(filepath.Join(dir, "src", "sub"), 0o755)
+	is.NoErr(err)
+	err = os.WriteFile(filepath.Join(dir, "src", "sub", "b.txt"), []byte("BBB"), 0o644)
+	is.NoErr(err)
+	err = run(context.Background(), []string{"-c", "-f", archive, "-C", dir, "src"}, nil, out, out)
+	is.NoErr(err)
+
+	handler, closer, err := newWebDAVHandler(context.Background(), archive)
+	is.NoErr(err)
+	defer closer.Close()
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/src/sub/b.txt")
+	is.NoErr(err)
+	body, err := io.ReadAll(resp.Body)
+	is.NoErr(err)
+	resp.Body.Close()
+	is.Equal(resp.StatusCode, http.StatusOK)
+	is.Equal(string(body), "BBB")
+
+	req, err := http.NewRequest("PROPFIND", srv.URL+"/src/", nil)
+	is.NoErr(err)
+	req.Header.Set("Depth", "1")
+	resp, err = http.DefaultClient.Do(req)
+	is.NoErr(err)
+	body, err = io.ReadAll(resp.Body)
+	is.NoErr(err)
+	resp.Body.Close()
+	is.Equal(resp.StatusCode, http.StatusMultiStatus)
+	is.True(strings.Contains(string(body), "/src/sub/"))
+
+	req, err = http.NewRequest(http.MethodPut, srv.URL+"/src/new.txt", strings.NewReader("new"))
+	is.NoErr(err)
+	resp, err = http.DefaultClient.Do(req)
+	is.NoErr(err)
+	resp.Body.Close()
+	is.True(resp.StatusCode >= 400)
+
+	req, err = http.NewRequest(http.MethodDelete, srv.URL+"/src/sub/b.txt", nil)
+	is.NoErr(err)
+	resp, err = http.DefaultClient.Do(req)
+	is.NoErr(err)
+	resp.Body.Close()
+	is.True(resp.StatusCode >= 400)
+}
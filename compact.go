@@ -0,0 +1,310 @@
+package pitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"sort"
+)
+
+// compactRestartInterval is the number of names between two names stored in full in a CompactTableOfContents.
+const compactRestartInterval = 16
+
+// CompactTableOfContents indexes the entries of an archive like a TableOfContents, in a fraction of the memory.
+// Names are kept sorted in one buffer, each stored as the length of the prefix it shares with the name before
+// it followed by the rest; offsets and sizes are kept in flat slices and header data in its encoded form.
+// A lookup costs a binary search and decoding at most compactRestartInterval names, and HeaderItems are
+// only created on demand.
+//
+// A CompactTableOfContents is immutable and safe for concurrent use.
+type CompactTableOfContents struct {
+	// names holds every name, in order, as a uvarint shared prefix length, a uvarint suffix length and the suffix.
+	names []byte
+	// restarts holds the offsets in names of every compactRestartInterval-th name, which share no prefix.
+	restarts []int
+	starts   []int64
+	// lengths holds the number of bytes stored for every entry, End-Start.
+	lengths []uint64
+	// sizes holds the sizes that differ from the number of bytes stored, those of sparse files.
+	sizes map[int]uint64
+	// data holds the encoded header data of every entry; that of entry i ends at dataEnds[i].
+	data     []byte
+	dataEnds []int
+
+	archiveData map[string][]string
+}
+
+// NewCompactTableOfContents returns a CompactTableOfContents holding the items of toc.
+func NewCompactTableOfContents(toc TableOfContents) *CompactTableOfContents {
+	var b compactBuilder
+	for _, item := range toc {
+		b.add(item)
+	}
+	return b.build()
+}
+
+// BuildCompactTableOfContents is like BuildTableOfContents but returns a CompactTableOfContents.
+// Items are read with ScanTableOfContents, so no TableOfContents is built on the way.
+func BuildCompactTableOfContents(v any) (*CompactTableOfContents, error) {
+	return BuildCompactTableOfContentsContext(context.Background(), v)
+}
+
+// BuildCompactTableOfContentsContext is like BuildCompactTableOfContents but gives up once ctx is done.
+func BuildCompactTableOfContentsContext(ctx context.Context, v any) (*CompactTableOfContents, error) {
+	var r Reader
+	switch x := v.(type) {
+	case []byte:
+		r = NewReader(bytes.NewReader(x))
+	case Reader:
+		r = x
+	case io.Reader:
+		r = NewReader(x)
+	default:
+		return nil, errors.New("expected []byte, Reader or io.Reader")
+	}
+
+	var b compactBuilder
+	for item, err := range ScanTableOfContentsContext(ctx, r) {
+		if err != nil {
+			return nil, err
+		}
+		b.add(item)
+	}
+	b.archiveData = ArchiveData(r)
+
+	if len(b.entries) == 0 && b.archiveData == nil {
+		return nil, io.EOF
+	}
+	return b.build(), nil
+}
+
+// Len returns the number of entries, not counting archive data.
+func (ct *CompactTableOfContents) Len() int {
+	return len(ct.starts)
+}
+
+// ArchiveData returns the archive data of the archive, or nil if there is none.
+func (ct *CompactTableOfContents) ArchiveData() map[string][]string {
+	return ct.archiveData
+}
+
+// Lookup returns the item of the entry named name.
+func (ct *CompactTableOfContents) Lookup(name string) (*HeaderItem, bool) {
+	// the last block starting with a name not after name holds it, if any
+	block := sort.Search(len(ct.restarts), func(i int) bool {
+		first, _ := ct.nameAt(ct.restarts[i], nil)
+		return name < string(first)
+	}) - 1
+	if block < 0 {
+		return nil, false
+	}
+
+	var (
+		buf []byte
+		off = ct.restarts[block]
+	)
+	for i := block * compactRestartInterval; i < min((block+1)*compactRestartInterval, ct.Len()); i++ {
+		buf, off = ct.nameAt(off, buf)
+		switch c := bytes.Compare(buf, []byte(name)); {
+		case c == 0:
+			return ct.item(i, name), true
+		case c > 0:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// All returns an iterator over the names and items of every entry, sorted by name.
+func (ct *CompactTableOfContents) All() iter.Seq2[string, *HeaderItem] {
+	return func(yield func(string, *HeaderItem) bool) {
+		var (
+			buf []byte
+			off int
+		)
+		for i := range ct.Len() {
+			buf, off = ct.nameAt(off, buf)
+			name := string(buf)
+			if !yield(name, ct.item(i, name)) {
+				return
+			}
+		}
+	}
+}
+
+// TableOfContents returns a TableOfContents holding the same items as ct.
+func (ct *CompactTableOfContents) TableOfContents() TableOfContents {
+	toc := make(TableOfContents, ct.Len())
+	for name, item := range ct.All() {
+		toc[name] = item
+	}
+	return toc
+}
+
+// nameAt decodes the name at off in ct.names, given the name before it in prev,
+// and returns it in prev's place along with the offset of the next name.
+func (ct *CompactTableOfContents) nameAt(off int, prev []byte) ([]byte, int) {
+	shared, n := binary.Uvarint(ct.names[off:])
+	off += n
+	length, n := binary.Uvarint(ct.names[off:])
+	off += n
+	name := append(prev[:shared], ct.names[off:off+int(length)]...)
+	return name, off + int(length)
+}
+
+func (ct *CompactTableOfContents) item(i int, name string) *HeaderItem {
+	item := HeaderItem{
+		Name:  name,
+		Size:  ct.lengths[i],
+		Start: ct.starts[i],
+		End:   ct.starts[i] + int64(ct.lengths[i]),
+	}
+	if size, ok := ct.sizes[i]; ok {
+		item.Size = size
+	}
+
+	dataStart := 0
+	if 0 < i {
+		dataStart = ct.dataEnds[i-1]
+	}
+	if dataStart < ct.dataEnds[i] {
+		// the data was encoded by compactBuilder.add, so it decodes
+		item.Data, _ = decodeData(ct.data[dataStart:ct.dataEnds[i]])
+	}
+	return &item
+}
+
+// compactBuilder collects items in flat buffers and sorts them into a CompactTableOfContents.
+type compactBuilder struct {
+	names       []byte
+	data        []byte
+	entries     []compactEntry
+	archiveData map[string][]string
+}
+
+type compactEntry struct {
+	// name and data delimit the name and encoded data of the entry in the buffers of the builder.
+	name, data   [2]int
+	start        int64
+	length, size uint64
+}
+
+func (b *compactBuilder) add(item *HeaderItem) {
+	e := compactEntry{
+		start:  item.Start,
+		length: uint64(item.End - item.Start),
+		size:   item.Size,
+	}
+
+	e.name[0] = len(b.names)
+	b.names = append(b.names, item.Name...)
+	e.name[1] = len(b.names)
+
+	e.data[0] = len(b.data)
+	if 0 < len(item.Data) {
+		buf := bytes.NewBuffer(b.data)
+		encodeData(buf, item.Data)
+		b.data = buf.Bytes()
+	}
+	e.data[1] = len(b.data)
+
+	b.entries = append(b.entries, e)
+}
+
+func (b *compactBuilder) build() *CompactTableOfContents {
+	name := func(e compactEntry) []byte {
+		return b.names[e.name[0]:e.name[1]]
+	}
+	slices.SortStableFunc(b.entries, func(x, y compactEntry) int {
+		return bytes.Compare(name(x), name(y))
+	})
+
+	var (
+		ct   = CompactTableOfContents{archiveData: b.archiveData}
+		prev []byte
+	)
+	for i, e := range b.entries {
+		n := name(e)
+		// later entries win, like they do in tables of contents
+		if i+1 < len(b.entries) && bytes.Equal(n, name(b.entries[i+1])) {
+			continue
+		}
+
+		var (
+			idx    = len(ct.starts)
+			shared int
+		)
+		if idx%compactRestartInterval == 0 {
+			ct.restarts = append(ct.restarts, len(ct.names))
+		} else {
+			for shared < min(len(prev), len(n)) && prev[shared] == n[shared] {
+				shared++
+			}
+		}
+		ct.names = binary.AppendUvarint(ct.names, uint64(shared))
+		ct.names = binary.AppendUvarint(ct.names, uint64(len(n)-shared))
+		ct.names = append(ct.names, n[shared:]...)
+		prev = n
+
+		ct.starts = append(ct.starts, e.start)
+		ct.lengths = append(ct.lengths, e.length)
+		if e.size != e.length {
+			if ct.sizes == nil {
+				ct.sizes = make(map[int]uint64)
+			}
+			ct.sizes[idx] = e.size
+		}
+		ct.data = append(ct.data, b.data[e.data[0]:e.data[1]]...)
+		ct.dataEnds = append(ct.dataEnds, len(ct.data))
+	}
+
+	ct.names = slices.Clip(ct.names)
+	ct.restarts = slices.Clip(ct.restarts)
+	ct.starts = slices.Clip(ct.starts)
+	ct.lengths = slices.Clip(ct.lengths)
+	ct.data = slices.Clip(ct.data)
+	ct.dataEnds = slices.Clip(ct.dataEnds)
+	return &ct
+}
+
+// decodeData decodes header data encoded by encodeData.
+func decodeData(b []byte) (map[string][]string, error) {
+	var (
+		r    = bytes.NewReader(b)
+		buf  = make([]byte, 1)
+		data = make(map[string][]string)
+		key  string
+		seen bool
+	)
+	for r.Len() != 0 {
+		s, err := DecodeSize(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(r.Len()) < s.Value {
+			return nil, io.ErrUnexpectedEOF
+		}
+		value := make([]byte, s.Value)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case s.Type == DataNameSize:
+			key, seen = string(value), true
+			if _, ok := data[key]; !ok {
+				data[key] = nil
+			}
+		case s.Type == DataValueSize && seen:
+			data[key] = append(data[key], string(value))
+		default:
+			return nil, fmt.Errorf("unexpected size of type %d in header data", s.Type)
+		}
+	}
+	return data, nil
+}
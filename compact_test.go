@@ -0,0 +1,136 @@
+package pitch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func newCompactTestArchive(t *testing.T) []byte {
+	var (
+		is      = is.New(t)
+		buf     = bytes.NewBuffer(nil)
+		w       = NewWriter(buf)
+		entries []testEntry
+	)
+	is.NoErr(w.SetArchiveData(map[string][]string{"Creator": {"test"}}))
+
+	for i := range 40 {
+		entries = append(entries, testEntry{name: fmt.Sprintf("dir/sub%d/file-%03d.txt", i%3, i), content: fmt.Sprint(i)})
+	}
+	entries = append(entries,
+		testEntry{name: "a", data: map[string][]string{"Empty": nil, "Owner": {"web", "db"}}},
+		testEntry{name: "dir/sub0/file-000.txt", content: "replaced"},
+		// 12 logical bytes with "ABC" at 2 and "DE" at 8
+		testEntry{name: "sparse", content: "ABCDE", data: map[string][]string{
+			DataKeySparseMap:  {"2:3", "8:2"},
+			DataKeySparseSize: {"12"},
+		}},
+	)
+	writeTestEntries(t, w, entries...)
+	is.NoErr(w.Close())
+
+	return buf.Bytes()
+}
+
+func TestCompactTableOfContents(t *testing.T) {
+	var (
+		is      = is.New(t)
+		archive = newCompactTestArchive(t)
+	)
+
+	toc, err := BuildTableOfContents(archive)
+	is.NoErr(err)
+	ct, err := BuildCompactTableOfContents(archive)
+	is.NoErr(err)
+
+	is.Equal(ct.Len(), len(toc))
+	is.True(1 < len(ct.restarts))
+	is.Equal(ct.ArchiveData(), map[string][]string{"Creator": {"test"}})
+	is.Equal(ct.TableOfContents(), toc)
+	is.Equal(NewCompactTableOfContents(toc).TableOfContents(), toc)
+
+	for name, want := range toc {
+		item, ok := ct.Lookup(name)
+		is.True(ok)
+		is.Equal(item, want)
+	}
+	for _, name := range []string{"", "0", "b", "dir", "dir/sub0/file-000", "dir/sub2/file-999.txt", "zzz"} {
+		_, ok := ct.Lookup(name)
+		is.True(!ok)
+	}
+
+	item, _ := ct.Lookup("sparse")
+	is.Equal(item.Size, uint64(12))
+	content, err := io.ReadAll(item.Content(bytes.NewReader(archive)))
+	is.NoErr(err)
+	is.Equal(string(content), "\x00\x00ABC\x00\x00\x00DE\x00\x00")
+
+	var (
+		names []string
+		prev  string
+	)
+	for name, item := range ct.All() {
+		is.True(prev < name)
+		is.Equal(item.Name, name)
+		names = append(names, name)
+		prev = name
+	}
+	is.Equal(len(names), ct.Len())
+}
+
+func TestCompactTableOfContents_Empty(t *testing.T) {
+	var is = is.New(t)
+
+	_, err := BuildCompactTableOfContents([]byte{})
+	is.True(errors.Is(err, io.EOF))
+
+	ct := NewCompactTableOfContents(TableOfContents{})
+	is.Equal(ct.Len(), 0)
+	_, ok := ct.Lookup("a")
+	is.True(!ok)
+}
+
+func TestScanTableOfContents(t *testing.T) {
+	var (
+		is      = is.New(t)
+		archive = newCompactTestArchive(t)
+		n       int
+	)
+
+	toc, err := BuildTableOfContents(archive)
+	is.NoErr(err)
+
+	var last = make(map[string]*HeaderItem)
+	for item, err := range ScanTableOfContents(NewReader(bytes.NewReader(archive))) {
+		is.NoErr(err)
+		last[item.Name] = item
+		n++
+	}
+	is.Equal(n, 43) // every entry, in archive order, duplicates included
+	for name, item := range last {
+		is.Equal(item, toc[name])
+	}
+
+	// stopping early
+	n = 0
+	for range ScanTableOfContents(NewReader(bytes.NewReader(archive))) {
+		n++
+		break
+	}
+	is.Equal(n, 1)
+
+	// truncated archives end early
+	n = 0
+	for _, err := range ScanTableOfContents(NewReader(bytes.NewReader(archive[:len(archive)/2]))) {
+		n++
+		if err != nil {
+			break
+		}
+	}
+	is.True(n < 43)
+}
@@ -0,0 +1,19 @@
+package pitch
+
+import (
+	"context"
+	"io"
+)
+
+// contextReader fails every read once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(b []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(b)
+}
@@ -0,0 +1,139 @@
+package pitch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+func TestReader_NextContext(t *testing.T) {
+	var (
+		is   = is.New(t)
+		fsys = fstest.MapFS{
+			"tree/a.txt": {Data: []byte(strings.Repeat("a", 1<<16))},
+			"tree/b.txt": {Data: []byte("BBB")},
+		}
+		archive     = newTestArchive(t, fsys, "tree")
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer cancel()
+
+	r := NewReader(bytes.NewBuffer(archive))
+	hdr, err := NextContext(ctx, r)
+	is.NoErr(err)
+	is.Equal(hdr.Name, "tree/a.txt")
+
+	// the reader has to skip over the content of a.txt to get to the next header
+	cancel()
+	hdr, err = NextContext(ctx, r)
+	is.True(hdr == nil)
+	is.True(errors.Is(err, context.Canceled))
+	is.True(strings.Contains(err.Error(), "tree/a.txt"))
+}
+
+// plainReader hides every method of a Reader but those of the Reader interface.
+type plainReader struct {
+	Reader
+}
+
+func TestContextCanceled(t *testing.T) {
+	var (
+		is   = is.New(t)
+		fsys = fstest.MapFS{
+			"tree/a.txt": {Data: []byte("AAA")},
+			"tree/b.txt": {Data: []byte("BBB")},
+		}
+		archive     = newTestArchive(t, fsys, "tree")
+		ctx, cancel = context.WithCancel(context.Background())
+
+		tests = []struct {
+			name string
+			run  func() error
+		}{
+			{
+				name: "build_table_of_contents",
+				run: func() error {
+					_, err := BuildTableOfContentsContext(ctx, bytes.NewBuffer(archive))
+					return err
+				},
+			},
+			{
+				name: "archive_fs",
+				run: func() error {
+					return ArchiveFSContext(ctx, &nopCloser{bytes.NewBuffer(nil)}, fsys, "tree", nil)
+				},
+			},
+			{
+				name: "archive_fs_parallel",
+				run: func() error {
+					return ArchiveFSContext(ctx, &nopCloser{bytes.NewBuffer(nil)}, fsys, "tree", &ArchiveOptions{
+						Concurrency: 4,
+					})
+				},
+			},
+			{
+				name: "archive_dir",
+				run: func() error {
+					return ArchiveDirContext(ctx, &nopCloser{bytes.NewBuffer(nil)}, t.TempDir(), nil)
+				},
+			},
+			{
+				name: "extract",
+				run: func() error {
+					return ExtractContext(ctx, t.TempDir(), NewReader(bytes.NewBuffer(archive)), nil)
+				},
+			},
+			{
+				name: "from_tar",
+				run: func() error {
+					var tarBuf bytes.Buffer
+					if err := ToTar(NewReader(bytes.NewReader(archive)), &tarBuf); err != nil {
+						return err
+					}
+					return FromTarContext(ctx, &tarBuf, NewWriter(io.Discard))
+				},
+			},
+			{
+				name: "to_tar",
+				run: func() error {
+					return ToTarContext(ctx, NewReader(bytes.NewBuffer(archive)), io.Discard)
+				},
+			},
+			{
+				name: "to_zip",
+				run: func() error {
+					return ToZipContext(ctx, bytes.NewReader(archive), int64(len(archive)), io.Discard)
+				},
+			},
+			{
+				name: "build_table_of_contents_plain_reader",
+				run: func() error {
+					_, err := BuildTableOfContentsContext(ctx, plainReader{NewReader(bytes.NewBuffer(archive))})
+					return err
+				},
+			},
+			{
+				name: "extract_plain_reader",
+				run: func() error {
+					return ExtractContext(ctx, t.TempDir(), plainReader{NewReader(bytes.NewBuffer(archive))}, nil)
+				},
+			},
+		}
+	)
+	cancel()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var is = is.New(t)
+
+			err := test.run()
+			is.True(errors.Is(err, context.Canceled))
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package pitch
+
+// Header data keys reserved by the pitch package.
+// Keys are stored alongside user-defined data, so they are namespaced to avoid collisions.
+const (
+	// DataKeyType holds the type of an entry.
+	// Entries without it are regular files.
+	DataKeyType = "Pitch-Type"
+	// DataKeyLinkTarget holds the target of a link entry.
+	DataKeyLinkTarget = "Pitch-Link-Target"
+	// DataKeyMode holds the permission bits of an entry, see FormatMode.
+	DataKeyMode = "Pitch-Mode"
+	// DataKeyModTime holds the modification time of an entry, see FormatModTime.
+	DataKeyModTime = "Pitch-Mod-Time"
+	// DataKeyUID holds the numeric user id of the owner of an entry.
+	DataKeyUID = "Pitch-Uid"
+	// DataKeyGID holds the numeric group id of the owner of an entry.
+	DataKeyGID = "Pitch-Gid"
+	// DataKeyUname holds the user name of the owner of an entry.
+	DataKeyUname = "Pitch-Uname"
+	// DataKeyGname holds the group name of the owner of an entry.
+	DataKeyGname = "Pitch-Gname"
+	// DataKeyPAXPrefix prefixes vendor specific PAX records carried over from tar archives,
+	// e.g. "Pitch-Pax-SCHILY.xattr.user.comment".
+	DataKeyPAXPrefix = "Pitch-Pax-"
+	// DataKeyComment holds the comment of an entry, such as the file comment of a zip entry.
+	DataKeyComment = "Pitch-Comment"
+	// DataKeyContentType holds the media type of the content of an entry, e.g. "text/html; charset=utf-8".
+	DataKeyContentType = "Pitch-Content-Type"
+	// DataKeyDigest holds a digest of the content of an entry, e.g. "sha256:<hex>".
+	// FileServer uses it as the ETag of the entry.
+	DataKeyDigest = "Pitch-Digest"
+	// DataKeyRefOffset holds the offset within the archive of the content a reference entry stands for.
+	DataKeyRefOffset = "Pitch-Ref-Offset"
+	// DataKeyRefSize holds the size of the content a reference entry stands for.
+	DataKeyRefSize = "Pitch-Ref-Size"
+	// DataKeySparseMap holds the data segments of a sparse file as "offset:length" values, in order.
+	// Only the segments are stored as content; everything between them is a hole.
+	// Readers and tables of contents present the logical content, with holes read as zeros.
+	DataKeySparseMap = "Pitch-Sparse-Map"
+	// DataKeySparseSize holds the logical size of a sparse file.
+	DataKeySparseSize = "Pitch-Sparse-Size"
+)
+
+// Entry types stored under DataKeyType.
+const (
+	// EntryTypeSymlink marks an empty entry that stands for a symbolic link.
+	EntryTypeSymlink = "symlink"
+	// EntryTypeHardlink marks an empty entry that stands for a hard link
+	// to the entry named by DataKeyLinkTarget.
+	EntryTypeHardlink = "hardlink"
+	// EntryTypeDir marks an empty entry that stands for a directory.
+	// Directories are implied by the names of the entries within them,
+	// so these are only needed to keep empty directories or directory metadata.
+	EntryTypeDir = "dir"
+	// EntryTypeRef marks an empty entry whose content is stored earlier in the archive,
+	// at DataKeyRefOffset. See Writer.WriteReference and ArchiveOptions.Dedup.
+	EntryTypeRef = "ref"
+	// EntryTypeArchiveData marks the empty entry named ArchiveDataName,
+	// whose data describes the whole archive. See Writer.SetArchiveData.
+	EntryTypeArchiveData = "archive-data"
+)
+
+// EntryType returns the type of the entry described by data, or the empty string for regular files.
+func EntryType(data map[string][]string) string {
+	if v := data[DataKeyType]; 0 < len(v) {
+		return v[0]
+	}
+	return ""
+}
+
+// isStorageKey reports whether the data key k describes how the content of an entry is stored
+// in a pitch archive rather than the entry itself, so it has no meaning in other formats.
+func isStorageKey(k string) bool {
+	switch k {
+	case DataKeySparseMap, DataKeySparseSize, DataKeyRefOffset, DataKeyRefSize:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package pitch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+)
+
+// digestPrefix prefixes the hex encoded SHA-256 digests stored under DataKeyDigest.
+const digestPrefix = "sha256:"
+
+// contentDigest returns the digest of everything read from r, formatted for DataKeyDigest.
+func contentDigest(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return digestPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dedupTarget is the content an entry was first written with.
+type dedupTarget struct {
+	offset int64
+	size   int64
+}
+
+// referenceRange returns the offset and size of the content the reference entry with data stands for.
+func referenceRange(data map[string][]string) (offset, size int64, err error) {
+	var off, sz string
+	if v := data[DataKeyRefOffset]; 0 < len(v) {
+		off = v[0]
+	}
+	if v := data[DataKeyRefSize]; 0 < len(v) {
+		sz = v[0]
+	}
+
+	if offset, err = strconv.ParseInt(off, 10, 64); err != nil || offset < 0 {
+		return 0, 0, fmt.Errorf("invalid reference offset %q", off)
+	}
+	if size, err = strconv.ParseInt(sz, 10, 64); err != nil || size < 0 {
+		return 0, 0, fmt.Errorf("invalid reference size %q", sz)
+	}
+	return offset, size, nil
+}
+
+// resolveReference returns the header of the regular file the reference entry hdr stands for,
+// along with the offset of its content.
+func resolveReference(hdr *Header) (*Header, int64, error) {
+	offset, size, err := referenceRange(hdr.Data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error resolving reference %s: %w", hdr.Name, err)
+	}
+
+	data := maps.Clone(hdr.Data)
+	delete(data, DataKeyType)
+	delete(data, DataKeyRefOffset)
+	delete(data, DataKeyRefSize)
+
+	return &Header{
+		Name: hdr.Name,
+		Size: uint64(size),
+		Data: data,
+	}, offset, nil
+}
@@ -0,0 +1,148 @@
+package pitch
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func dedupTestFS() fstest.MapFS {
+	var (
+		modTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		big     = []byte(strings.Repeat("layer", 1000))
+	)
+	return fstest.MapFS{
+		"tree/a/lib.so":  {Data: big, Mode: 0o755, ModTime: modTime},
+		"tree/b/lib.so":  {Data: big, Mode: 0o644, ModTime: modTime},
+		"tree/c/lib.so":  {Data: big, Mode: 0o600, ModTime: modTime},
+		"tree/other.txt": {Data: []byte("other"), Mode: 0o644, ModTime: modTime},
+		"tree/empty1":    {Data: []byte{}, Mode: 0o644, ModTime: modTime},
+		"tree/empty2":    {Data: []byte{}, Mode: 0o644, ModTime: modTime},
+	}
+}
+
+func TestArchiveFS_Dedup(t *testing.T) {
+	var (
+		is   = is.New(t)
+		fsys = dedupTestFS()
+	)
+
+	for _, concurrency := range []int{1, 4} {
+		buf := bytes.NewBuffer(nil)
+		err := ArchiveFS(&nopCloser{buf}, fsys, "tree", &ArchiveOptions{
+			Metadata:    true,
+			Dedup:       true,
+			Concurrency: concurrency,
+		})
+		is.NoErr(err)
+		archive := buf.Bytes()
+		is.True(len(archive) < 2*len(fsys["tree/a/lib.so"].Data)) // the copies are stored once
+
+		// a reader with random access resolves references
+		contents, hdrs, err := readArchive(NewReader(bytes.NewReader(archive)))
+		is.NoErr(err)
+		for name, file := range fsys {
+			is.Equal(contents[name], string(file.Data))
+			is.Equal(EntryType(hdrs[name].Data), "")
+			is.Equal(hdrs[name].Size, uint64(len(file.Data)))
+			is.True(strings.HasPrefix(hdrs[name].Data[DataKeyDigest][0], "sha256:"))
+		}
+		is.Equal(hdrs["tree/b/lib.so"].Data[DataKeyMode], []string{"0644"})
+
+		// a plain stream hands them out as they are
+		_, hdrs, err = readArchive(NewReader(struct{ io.Reader }{bytes.NewReader(archive)}))
+		is.NoErr(err)
+		is.Equal(EntryType(hdrs["tree/b/lib.so"].Data), EntryTypeRef)
+
+		toc, err := BuildTableOfContents(archive)
+		is.NoErr(err)
+		content, err := io.ReadAll(toc["tree/c/lib.so"].Content(bytes.NewReader(archive)))
+		is.NoErr(err)
+		is.Equal(content, fsys["tree/c/lib.so"].Data)
+		is.Equal(toc["tree/c/lib.so"].Start, toc["tree/a/lib.so"].Start)
+
+		toc, err = BuildTableOfContents(struct{ io.Reader }{bytes.NewReader(archive)})
+		is.NoErr(err)
+		is.Equal(toc["tree/c/lib.so"].Start, toc["tree/a/lib.so"].Start)
+		is.Equal(toc["tree/c/lib.so"].Size, uint64(len(fsys["tree/c/lib.so"].Data)))
+		is.Equal(toc["tree/other.txt"].End-toc["tree/other.txt"].Start, int64(5))
+
+		// extraction copies references from the first copy when reading a stream
+		dir := t.TempDir()
+		err = Extract(dir, NewReader(struct{ io.Reader }{bytes.NewReader(archive)}), nil)
+		is.NoErr(err)
+		checkExtracted(is, dir, fsys)
+
+		dir = t.TempDir()
+		err = ExtractAt(t.Context(), dir, bytes.NewReader(archive), toc, nil)
+		is.NoErr(err)
+		checkExtracted(is, dir, fsys)
+	}
+}
+
+func TestWriter_WriteReference(t *testing.T) {
+	var (
+		is  = is.New(t)
+		buf = bytes.NewBuffer(nil)
+		w   = NewWriter(buf)
+	)
+
+	_, err := w.WriteReference("early", 0, 1, nil)
+	is.Equal(err, ErrInvalidSize) // nothing was written yet
+
+	_, err = w.WriteHeader("a.txt", 3, nil)
+	is.NoErr(err)
+	offset := w.Offset()
+	_, err = w.Write([]byte("AAA"))
+	is.NoErr(err)
+	is.Equal(w.Offset(), int64(buf.Len()))
+
+	_, err = w.WriteReference("b.txt", offset, 3, map[string][]string{"k": {"v"}})
+	is.NoErr(err)
+	is.NoErr(w.Close())
+
+	contents, hdrs, err := readArchive(NewReader(bytes.NewReader(buf.Bytes())))
+	is.NoErr(err)
+	is.Equal(contents["b.txt"], "AAA")
+	is.Equal(hdrs["b.txt"].Data, map[string][]string{"k": {"v"}})
+}
+
+func TestArchiveDirs_Dedup(t *testing.T) {
+	var (
+		is    = is.New(t)
+		dir   = t.TempDir()
+		big   = strings.Repeat("layer", 1000)
+		files = map[string]string{
+			"one/lib.so":   big,
+			"two/lib.so":   big,
+			"two/a.txt":    "other",
+			"two/copy.txt": "other",
+		}
+		buf = bytes.NewBuffer(nil)
+	)
+
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		is.NoErr(os.MkdirAll(filepath.Dir(p), 0o755))
+		is.NoErr(os.WriteFile(p, []byte(content), 0o644))
+	}
+
+	err := ArchiveDirs(&nopCloser{buf}, []string{filepath.Join(dir, "one"), filepath.Join(dir, "two")}, &ArchiveOptions{
+		Dedup: true,
+	})
+	is.NoErr(err)
+	is.True(buf.Len() < 2*len(big)) // the copy in the second directory refers to the first one
+
+	contents, _, err := readArchive(NewReader(bytes.NewReader(buf.Bytes())))
+	is.NoErr(err)
+	for name, content := range files {
+		is.Equal(contents[name], content)
+	}
+}
@@ -0,0 +1,331 @@
+package pitch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ChangeKind is the set of ways an entry differs between two trees, see Diff.
+type ChangeKind uint8
+
+const (
+	// ChangeAdded marks entries only found in the new tree.
+	ChangeAdded ChangeKind = 1 << iota
+	// ChangeRemoved marks entries only found in the old tree.
+	ChangeRemoved
+	// ChangeSize marks entries whose size changed.
+	ChangeSize
+	// ChangeData marks entries whose header data changed, such as their mode or modification time.
+	ChangeData
+	// ChangeContent marks entries whose content changed while their size did not.
+	ChangeContent
+)
+
+var changeKindNames = []string{"added", "removed", "size", "data", "content"}
+
+// String returns the names of the kinds of changes in k, separated by commas.
+func (k ChangeKind) String() string {
+	var names []string
+	for i, name := range changeKindNames {
+		if k&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Change describes an entry that differs between two trees.
+type Change struct {
+	Name string
+	Kind ChangeKind
+	// DataKeys holds the sorted header data keys whose values changed.
+	DataKeys []string
+	// Old and New are the items of the entry in the old and the new tree.
+	// Old is nil for added entries and New is nil for removed ones.
+	Old, New *HeaderItem
+}
+
+// DiffOptions configures how trees are compared.
+// A nil *DiffOptions is equivalent to the zero value.
+type DiffOptions struct {
+	// IgnoreData lists header data keys whose changes are not reported, e.g. DataKeyModTime.
+	IgnoreData []string
+	// Contents compares the contents of regular files whose sizes match but which do not both record
+	// a digest under DataKeyDigest, byte by byte. Diff has no contents to read and ignores it.
+	Contents bool
+}
+
+// diffStorageKeys are header data keys that describe how an entry is stored rather than the file it stands for.
+var diffStorageKeys = []string{DataKeyRefOffset, DataKeyRefSize, DataKeySparseMap, DataKeySparseSize, DataKeyDigest}
+
+// Diff returns the changes between the entries of a, the old tree, and those of b, the new one, sorted by name.
+// Only tables of contents are compared: contents are only found to differ through the digests
+// both sides record under DataKeyDigest, see ArchiveOptions.Dedup. Archive data is not compared.
+func Diff(a, b TableOfContents, opts *DiffOptions) []Change {
+	// nothing is read, so nothing fails
+	changes, _ := diffTables(context.Background(), a, b, opts, nil)
+	return changes
+}
+
+// DiffArchives is like Diff but reads the contents of the entries of a from ra and those of b from rb,
+// so that opts.Contents can be honored. It gives up once ctx is done.
+func DiffArchives(ctx context.Context, ra io.ReaderAt, a TableOfContents, rb io.ReaderAt, b TableOfContents, opts *DiffOptions) ([]Change, error) {
+	return diffTables(ctx, a, b, opts, func(x, y *HeaderItem) (bool, error) {
+		return sameContent(ctx, x.Content(ra), y.Content(rb))
+	})
+}
+
+// DiffDir is DiffFS over the directory dir, whose files are looked for under the names ArchiveDir gives them:
+// the archive of a directory is compared to the directory itself.
+func DiffDir(ctx context.Context, ra io.ReaderAt, toc TableOfContents, dir string, opts *DiffOptions) ([]Change, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error getting absolute path: %w", err)
+	}
+	// names start with the base name of dir, unless it is the root of a volume
+	return DiffFS(ctx, ra, toc, os.DirFS(filepath.Dir(abs)), opts)
+}
+
+// DiffFS compares the entries of toc, read from ra, to the files of the same names in fsys, like tar --diff:
+// only the entries of the archive are looked for, so Changes never have the kind ChangeAdded, and entries
+// missing from fsys are reported as removed. Only the header data keys an entry records are compared,
+// along with its type and link target, so archives made without metadata are not reported as changed.
+// User and group names are not compared, since files only record IDs.
+// Hard links are compared as copies of the files they link to.
+func DiffFS(ctx context.Context, ra io.ReaderAt, toc TableOfContents, fsys fs.FS, opts *DiffOptions) ([]Change, error) {
+	var (
+		d       = newDiffer(opts)
+		changes []Change
+	)
+	for name, item := range toc.ByName() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !fs.ValidPath(name) || name == "." {
+			return nil, fmt.Errorf("%w: %q", ErrInsecurePath, name)
+		}
+
+		info, err := fs.Lstat(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			changes = append(changes, Change{Name: name, Kind: ChangeRemoved, Old: item})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error getting file info: %w", err)
+		}
+
+		if EntryType(item.Data) == EntryTypeHardlink {
+			if item, err = hardlinkCopy(toc, item); err != nil {
+				return nil, err
+			}
+		}
+		live, err := fsItem(fsys, name, info)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", name, err)
+		}
+
+		keys := append(slices.Collect(maps.Keys(item.Data)), DataKeyType, DataKeyLinkTarget)
+		keys = slices.DeleteFunc(keys, func(k string) bool {
+			return k == DataKeyUname || k == DataKeyGname
+		})
+		c, err := d.compare(item, live, keys, func(x, _ *HeaderItem) (bool, error) {
+			f, err := fsys.Open(name)
+			if err != nil {
+				return false, err
+			}
+			defer f.Close()
+			return sameContent(ctx, x.Content(ra), f)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error comparing %s: %w", name, err)
+		}
+		if c.Kind != 0 {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+func diffTables(ctx context.Context, a, b TableOfContents, opts *DiffOptions, contents func(x, y *HeaderItem) (bool, error)) ([]Change, error) {
+	var (
+		d       = newDiffer(opts)
+		changes []Change
+	)
+	for name, x := range a.ByName() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		y, ok := b[name]
+		if !ok {
+			changes = append(changes, Change{Name: name, Kind: ChangeRemoved, Old: x})
+			continue
+		}
+
+		keys := append(slices.Collect(maps.Keys(x.Data)), slices.Collect(maps.Keys(y.Data))...)
+		c, err := d.compare(x, y, keys, contents)
+		if err != nil {
+			return nil, fmt.Errorf("error comparing %s: %w", name, err)
+		}
+		if c.Kind != 0 {
+			changes = append(changes, c)
+		}
+	}
+
+	for name, y := range b.All() {
+		if _, ok := a[name]; !ok {
+			changes = append(changes, Change{Name: name, Kind: ChangeAdded, New: y})
+		}
+	}
+	slices.SortFunc(changes, func(x, y Change) int {
+		return strings.Compare(x.Name, y.Name)
+	})
+	return changes, nil
+}
+
+type differ struct {
+	ignore   []string
+	contents bool
+}
+
+func newDiffer(opts *DiffOptions) *differ {
+	if opts == nil {
+		opts = &DiffOptions{}
+	}
+	return &differ{
+		ignore:   append(slices.Clone(opts.IgnoreData), diffStorageKeys...),
+		contents: opts.Contents,
+	}
+}
+
+// compare compares the values of keys in the data of x and y, their sizes and, through contents if it is
+// not nil and the options ask for it, the contents of regular files of the same size without digests.
+func (d *differ) compare(x, y *HeaderItem, keys []string, contents func(x, y *HeaderItem) (bool, error)) (Change, error) {
+	c := Change{
+		Name: x.Name,
+		Old:  x,
+		New:  y,
+	}
+
+	slices.Sort(keys)
+	for _, k := range slices.Compact(keys) {
+		if slices.Contains(d.ignore, k) {
+			continue
+		}
+		if !slices.Equal(x.Data[k], y.Data[k]) {
+			c.DataKeys = append(c.DataKeys, k)
+		}
+	}
+	if 0 < len(c.DataKeys) {
+		c.Kind |= ChangeData
+	}
+
+	if x.Size != y.Size {
+		c.Kind |= ChangeSize
+		return c, nil
+	}
+	if x.Size == 0 || EntryType(x.Data) != "" || EntryType(y.Data) != "" {
+		return c, nil
+	}
+
+	dx, dy := x.Data[DataKeyDigest], y.Data[DataKeyDigest]
+	switch {
+	case 0 < len(dx) && 0 < len(dy):
+		if dx[0] != dy[0] {
+			c.Kind |= ChangeContent
+		}
+	case d.contents && contents != nil:
+		same, err := contents(x, y)
+		if err != nil {
+			return c, err
+		}
+		if !same {
+			c.Kind |= ChangeContent
+		}
+	}
+	return c, nil
+}
+
+// hardlinkCopy returns the item of the file the hard link entry item links to, under the name and data of item.
+func hardlinkCopy(toc TableOfContents, item *HeaderItem) (*HeaderItem, error) {
+	var target string
+	if v := item.Data[DataKeyLinkTarget]; 0 < len(v) {
+		target = v[0]
+	}
+	linked, ok := toc[target]
+	if !ok || EntryType(linked.Data) != "" {
+		return nil, fmt.Errorf("error reading %s: hard link to missing file %q", item.Name, target)
+	}
+
+	cp := *linked
+	cp.Name = item.Name
+	cp.Data = maps.Clone(item.Data)
+	delete(cp.Data, DataKeyType)
+	delete(cp.Data, DataKeyLinkTarget)
+	if v := linked.Data[DataKeyDigest]; 0 < len(v) {
+		cp.Data[DataKeyDigest] = v
+	}
+	return &cp, nil
+}
+
+// fsItem returns an item describing the file named name in fsys the way an archive with metadata would.
+// Its range is left empty.
+func fsItem(fsys fs.FS, name string, info fs.FileInfo) (*HeaderItem, error) {
+	item := HeaderItem{
+		Name: name,
+		Data: fileData(info, &ArchiveOptions{Metadata: true}),
+	}
+
+	switch mode := info.Mode(); {
+	case mode.IsRegular():
+		item.Size = uint64(info.Size())
+	case mode.IsDir():
+		item.Data[DataKeyType] = []string{EntryTypeDir}
+	case mode&fs.ModeSymlink != 0:
+		target, err := fs.ReadLink(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		item.Data[DataKeyType] = []string{EntryTypeSymlink}
+		item.Data[DataKeyLinkTarget] = []string{target}
+	default:
+		item.Data[DataKeyType] = []string{mode.Type().String()}
+	}
+	return &item, nil
+}
+
+// sameContent reports whether x and y read the same bytes.
+func sameContent(ctx context.Context, x, y io.Reader) (bool, error) {
+	var (
+		bx = make([]byte, 32*1024)
+		by = make([]byte, len(bx))
+		rx = &contextReader{ctx: ctx, r: x}
+	)
+	for {
+		nx, errX := io.ReadFull(rx, bx)
+		ny, errY := io.ReadFull(y, by)
+		if !bytes.Equal(bx[:nx], by[:ny]) {
+			return false, nil
+		}
+
+		eofX := errors.Is(errX, io.EOF) || errors.Is(errX, io.ErrUnexpectedEOF)
+		eofY := errors.Is(errY, io.EOF) || errors.Is(errY, io.ErrUnexpectedEOF)
+		switch {
+		case errX != nil && !eofX:
+			return false, errX
+		case errY != nil && !eofY:
+			return false, errY
+		case eofX || eofY:
+			return eofX == eofY, nil
+		}
+	}
+}
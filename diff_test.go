@@ -0,0 +1,170 @@
+package pitch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestDiff(t *testing.T) {
+	var is = is.New(t)
+
+	archiveA := writeTestArchive(t,
+		testEntry{name: "same.txt", content: "same", data: map[string][]string{DataKeyMode: {"-rw-r--r--"}}},
+		testEntry{name: "removed.txt", content: "gone"},
+		testEntry{name: "grown.txt", content: "abc"},
+		testEntry{name: "mode.txt", content: "m", data: map[string][]string{DataKeyMode: {"-rw-r--r--"}}},
+		testEntry{name: "edited.txt", content: "aaaa"},
+		testEntry{name: "digest.txt", content: "dddd", data: map[string][]string{DataKeyDigest: {"sha256:1"}}},
+	)
+	archiveB := writeTestArchive(t,
+		testEntry{name: "added.txt", content: "new"},
+		testEntry{name: "same.txt", content: "same", data: map[string][]string{DataKeyMode: {"-rw-r--r--"}}},
+		testEntry{name: "grown.txt", content: "abcd"},
+		testEntry{name: "mode.txt", content: "m", data: map[string][]string{DataKeyMode: {"-rwxr-xr-x"}}},
+		testEntry{name: "edited.txt", content: "aaab"},
+		testEntry{name: "digest.txt", content: "eeee", data: map[string][]string{DataKeyDigest: {"sha256:2"}}},
+	)
+
+	a, err := BuildTableOfContents(archiveA)
+	is.NoErr(err)
+	b, err := BuildTableOfContents(archiveB)
+	is.NoErr(err)
+
+	summary := func(changes []Change) map[string]string {
+		m := make(map[string]string)
+		for _, c := range changes {
+			m[c.Name] = c.Kind.String()
+		}
+		return m
+	}
+
+	changes := Diff(a, b, nil)
+	is.Equal(summary(changes), map[string]string{
+		"added.txt":   "added",
+		"removed.txt": "removed",
+		"grown.txt":   "size",
+		"mode.txt":    "data",
+		"digest.txt":  "content",
+	})
+	is.Equal(changes[0].Name, "added.txt") // sorted by name
+	is.Equal(changes[0].Old, nil)
+	for _, c := range changes {
+		if c.Name == "mode.txt" {
+			is.Equal(c.DataKeys, []string{DataKeyMode})
+		}
+	}
+
+	changes = Diff(a, b, &DiffOptions{IgnoreData: []string{DataKeyMode}})
+	is.Equal(len(changes), 4)
+
+	changes, err = DiffArchives(context.Background(), bytes.NewReader(archiveA), a, bytes.NewReader(archiveB), b, &DiffOptions{Contents: true})
+	is.NoErr(err)
+	is.Equal(summary(changes)["edited.txt"], "content")
+	is.Equal(len(changes), 6)
+
+	is.Equal(len(Diff(a, a, nil)), 0)
+	is.Equal((ChangeSize | ChangeData).String(), "size,data")
+}
+
+func TestDiffDir(t *testing.T) {
+	var (
+		is      = is.New(t)
+		dir     = t.TempDir()
+		src     = filepath.Join(dir, "src")
+		buf     = bytes.NewBuffer(nil)
+		modTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	is.NoErr(os.MkdirAll(filepath.Join(src, "sub"), 0o755))
+	is.NoErr(os.WriteFile(filepath.Join(src, "a.txt"), []byte("AAA"), 0o644))
+	is.NoErr(os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("BBB"), 0o644))
+	is.NoErr(os.WriteFile(filepath.Join(src, "sub", "c.txt"), []byte("CCC"), 0o644))
+	for _, name := range []string{"a.txt", "sub/b.txt", "sub/c.txt"} {
+		is.NoErr(os.Chtimes(filepath.Join(src, name), modTime, modTime))
+	}
+
+	is.NoErr(ArchiveDirWithOptions(&nopCloser{buf}, src, &ArchiveOptions{Metadata: true}))
+	archive := buf.Bytes()
+	toc, err := BuildTableOfContents(archive)
+	is.NoErr(err)
+	ra := bytes.NewReader(archive)
+
+	diffDir := func(opts *DiffOptions) map[string]string {
+		changes, err := DiffDir(context.Background(), ra, toc, src, opts)
+		is.NoErr(err)
+		m := make(map[string]string)
+		for _, c := range changes {
+			m[c.Name] = c.Kind.String()
+		}
+		return m
+	}
+	is.Equal(diffDir(&DiffOptions{Contents: true}), map[string]string{})
+
+	is.NoErr(os.WriteFile(filepath.Join(src, "a.txt"), []byte("AAB"), 0o644))
+	is.NoErr(os.Chtimes(filepath.Join(src, "a.txt"), modTime, modTime))
+	is.NoErr(os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("BBBB"), 0o644))
+	is.NoErr(os.Chmod(filepath.Join(src, "sub", "c.txt"), 0o600))
+	is.NoErr(os.WriteFile(filepath.Join(src, "new.txt"), []byte("new"), 0o644))
+
+	opts := &DiffOptions{IgnoreData: []string{DataKeyModTime}}
+	is.Equal(diffDir(opts), map[string]string{
+		"src/sub/b.txt": "size",
+		"src/sub/c.txt": "data",
+	})
+	opts.Contents = true
+	is.Equal(diffDir(opts), map[string]string{
+		"src/a.txt":     "content",
+		"src/sub/b.txt": "size",
+		"src/sub/c.txt": "data",
+	})
+
+	is.NoErr(os.RemoveAll(filepath.Join(src, "sub")))
+	changes, err := DiffDir(context.Background(), ra, toc, src, opts)
+	is.NoErr(err)
+	for _, c := range changes {
+		if c.Name != "src/a.txt" {
+			is.Equal(c.Kind, ChangeRemoved)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = DiffDir(ctx, ra, toc, src, nil)
+	is.True(errors.Is(err, context.Canceled))
+}
+
+func TestDiffDir_Archived(t *testing.T) {
+	var (
+		is  = is.New(t)
+		dir = filepath.Join(t.TempDir(), "src")
+		buf = bytes.NewBuffer(nil)
+	)
+
+	is.NoErr(os.MkdirAll(filepath.Join(dir, "sub"), 0o755))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "a.txt"), []byte("AAA"), 0o644))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("BBB"), 0o644))
+
+	is.NoErr(ArchiveDirWithOptions(&nopCloser{buf}, dir, &ArchiveOptions{Metadata: true}))
+	toc, err := BuildTableOfContents(buf.Bytes())
+	is.NoErr(err)
+	ra := bytes.NewReader(buf.Bytes())
+
+	// a directory matches its own archive
+	changes, err := DiffDir(context.Background(), ra, toc, dir, &DiffOptions{Contents: true})
+	is.NoErr(err)
+	is.Equal(len(changes), 0)
+
+	// user and group names from tar archives are not compared
+	toc["src/a.txt"].Data[DataKeyUname] = []string{"someone"}
+	toc["src/a.txt"].Data[DataKeyGname] = []string{"someone"}
+	changes, err = DiffDir(context.Background(), ra, toc, dir, nil)
+	is.NoErr(err)
+	is.Equal(len(changes), 0)
+}
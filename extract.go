@@ -0,0 +1,506 @@
+package pitch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// ErrInsecurePath is returned when extracting an entry whose name is not a valid, relative, unrooted path.
+var ErrInsecurePath = errors.New("pitch: insecure entry name")
+
+// ExtractOptions configures how archive entries are written to disk.
+// A nil *ExtractOptions is equivalent to the zero value.
+type ExtractOptions struct {
+	// Concurrency is the number of entries ExtractAt extracts at once.
+	// Defaults to runtime.GOMAXPROCS(0).
+	Concurrency int
+	// Observer, if set, is notified as every entry is extracted.
+	// Calls are never concurrent, even when ExtractAt extracts entries at once.
+	Observer Observer
+}
+
+// Extract writes every entry read from r into the directory dst, creating dst if needed.
+// Entries may not refer to locations outside of dst, see ErrInsecurePath.
+//
+// Header data written by ArchiveOptions.Metadata is used to restore modes and modification times.
+// Symlinks and hard links are created after every other entry, and directories get their metadata last.
+// Hard links are copied from the files they link to where links cannot be made.
+func Extract(dst string, r Reader, opts *ExtractOptions) error {
+	return ExtractContext(context.Background(), dst, r, opts)
+}
+
+// ExtractContext is like Extract but gives up once ctx is done.
+func ExtractContext(ctx context.Context, dst string, r Reader, opts *ExtractOptions) error {
+	x, err := newExtractor(dst, opts)
+	if err != nil {
+		return err
+	}
+	defer x.root.Close()
+
+	// offsets maps where the content of the files extracted so far lies within the archive to their names,
+	// so references the reader hands out as they are can be copied from the first copy
+	var (
+		offsets   = make(map[int64]string)
+		ranger, _ = r.(contentRanger)
+	)
+
+	for {
+		hdr, err := NextContext(ctx, r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading header: %w", err)
+		}
+
+		switch EntryType(hdr.Data) {
+		case EntryTypeRef:
+			err = x.extractReference(hdr, offsets)
+		case "":
+			if ranger != nil {
+				start, _ := ranger.contentRange()
+				if _, ok := offsets[start]; !ok {
+					offsets[start] = hdr.Name
+				}
+			}
+			fallthrough
+		default:
+			err = x.extract(hdr, &contextReader{ctx: ctx, r: r})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return x.finish()
+}
+
+// extractReference extracts the reference entry hdr by copying the file its content was first extracted to.
+func (x *extractor) extractReference(hdr *Header, offsets map[int64]string) error {
+	resolved, offset, err := resolveReference(hdr)
+	if err != nil {
+		return err
+	}
+	// the first copy was extracted with its logical content, holes included
+	_, size, sparse, err := expandSparse(resolved.Data, resolved.Size)
+	if err != nil {
+		return fmt.Errorf("error extracting %s: %w", hdr.Name, err)
+	}
+	if sparse {
+		resolved.Size = uint64(size)
+	}
+
+	src, ok := offsets[offset]
+	if !ok {
+		return fmt.Errorf("error extracting %s: no file was extracted from offset %d", hdr.Name, offset)
+	}
+	srcName, err := localName(src)
+	if err != nil {
+		return err
+	}
+
+	file, err := x.root.Open(srcName)
+	if err != nil {
+		return fmt.Errorf("error extracting %s: %w", hdr.Name, err)
+	}
+	defer file.Close()
+
+	return x.extract(resolved, file)
+}
+
+// ExtractAt writes the entries in toc into the directory dst, reading their contents from ra.
+// Since every entry is an independent byte range, up to opts.Concurrency entries are extracted at once.
+// It stops at the first error or when ctx is done.
+//
+// See Extract for how entries are written.
+func ExtractAt(ctx context.Context, dst string, ra io.ReaderAt, toc TableOfContents, opts *ExtractOptions) error {
+	x, err := newExtractor(dst, opts)
+	if err != nil {
+		return err
+	}
+	defer x.root.Close()
+
+	// reading in archive order keeps access to ra mostly sequential
+	var items []*HeaderItem
+	for _, item := range toc.ByLocation() {
+		items = append(items, item)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var (
+		concurrency = x.opts.Concurrency
+		jobs        = make(chan *HeaderItem)
+		workers     sync.WaitGroup
+
+		// firstErr names the entry that failed, which the cause of ctx may not
+		errOnce  sync.Once
+		firstErr error
+	)
+	if concurrency < 1 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for item := range jobs {
+				content := contextReader{
+					ctx: ctx,
+					r:   item.Content(ra),
+				}
+				if err := x.extract(item.Header(), &content); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+					})
+					cancel(err)
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, item := range items {
+		select {
+		case jobs <- item:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	workers.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
+
+	return x.finish()
+}
+
+type extractor struct {
+	root *os.Root
+	opts ExtractOptions
+
+	mu sync.Mutex
+	// links holds the symlinks to create once everything else is extracted.
+	links []*Header
+	// hardlinks holds the hard links to create once the files they link to are extracted.
+	hardlinks []*Header
+	// dirs holds the directories whose metadata is applied once everything else is extracted.
+	dirs []*Header
+}
+
+func newExtractor(dst string, opts *ExtractOptions) (*extractor, error) {
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating destination: %w", err)
+	}
+
+	root, err := os.OpenRoot(dst)
+	if err != nil {
+		return nil, fmt.Errorf("error opening destination: %w", err)
+	}
+
+	x := extractor{
+		root: root,
+	}
+	if opts != nil {
+		x.opts = *opts
+	}
+	return &x, nil
+}
+
+// extract writes the entry described by hdr, whose content is read from content.
+// It is safe to call concurrently.
+func (x *extractor) extract(hdr *Header, content io.Reader) error {
+	name, err := localName(hdr.Name)
+	if err != nil {
+		return err
+	}
+
+	switch typ := EntryType(hdr.Data); typ {
+	case "":
+	case EntryTypeSymlink:
+		x.mu.Lock()
+		x.links = append(x.links, hdr)
+		x.mu.Unlock()
+		return nil
+	case EntryTypeHardlink:
+		x.mu.Lock()
+		x.hardlinks = append(x.hardlinks, hdr)
+		x.mu.Unlock()
+		return nil
+	case EntryTypeDir:
+		x.start(hdr)
+		err = x.mkdirAll(name)
+		if err != nil {
+			err = fmt.Errorf("error extracting %s: %w", hdr.Name, err)
+		} else {
+			x.mu.Lock()
+			x.dirs = append(x.dirs, hdr)
+			x.mu.Unlock()
+		}
+		x.done(hdr, err)
+		return err
+	default:
+		return fmt.Errorf("error extracting %s: unsupported entry type %q", hdr.Name, typ)
+	}
+
+	x.start(hdr)
+	err = x.extractFile(name, hdr, content)
+	if err != nil {
+		err = fmt.Errorf("error extracting %s: %w", hdr.Name, err)
+	}
+	x.done(hdr, err)
+
+	return err
+}
+
+func (x *extractor) extractFile(name string, hdr *Header, content io.Reader) error {
+	if err := x.mkdirAll(filepath.Dir(name)); err != nil {
+		return err
+	}
+
+	file, err := x.root.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o666)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = file
+
+	// holes are recreated by only writing the data segments of sparse files
+	segs, size, sparse, err := parseSparseMap(hdr.Data)
+	if err == nil && sparse && uint64(size) != hdr.Size {
+		err = errors.New("sparse size does not match the size of the entry")
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if sparse {
+		w = &sparseWriter{
+			f:    file,
+			segs: segs,
+		}
+	}
+
+	if o := x.opts.Observer; o != nil {
+		w = &progressWriter{
+			w: w,
+			progress: func(n int64) {
+				x.mu.Lock()
+				defer x.mu.Unlock()
+				o.EntryProgress(hdr, n)
+			},
+		}
+	}
+
+	if _, err := io.CopyN(w, content, int64(hdr.Size)); err != nil {
+		file.Close()
+		return err
+	}
+
+	if sparse {
+		if err := file.Truncate(size); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return x.applyMetadata(name, hdr.Data)
+}
+
+// finish creates the links and applies the directory metadata that were put off by extract.
+func (x *extractor) finish() error {
+	for _, hdr := range x.links {
+		x.start(hdr)
+		err := x.extractLink(hdr)
+		if err != nil {
+			err = fmt.Errorf("error extracting %s: %w", hdr.Name, err)
+		}
+		x.done(hdr, err)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, hdr := range x.hardlinks {
+		x.start(hdr)
+		err := x.extractHardlink(hdr)
+		if err != nil {
+			err = fmt.Errorf("error extracting %s: %w", hdr.Name, err)
+		}
+		x.done(hdr, err)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// a read-only directory would stop its entries from being written, so its mode is applied last
+	for _, hdr := range x.dirs {
+		name, err := localName(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err := x.applyMetadata(name, hdr.Data); err != nil {
+			return fmt.Errorf("error extracting %s: %w", hdr.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (x *extractor) extractLink(hdr *Header) error {
+	name, err := localName(hdr.Name)
+	if err != nil {
+		return err
+	}
+
+	var target string
+	if v := hdr.Data[DataKeyLinkTarget]; 0 < len(v) {
+		target = v[0]
+	}
+	if target == "" {
+		return errors.New("missing link target")
+	}
+
+	if err := x.mkdirAll(filepath.Dir(name)); err != nil {
+		return err
+	}
+	if err := x.root.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return x.root.Symlink(filepath.FromSlash(target), name)
+}
+
+// extractHardlink links hdr to the file it names, or copies that file where links cannot be made.
+func (x *extractor) extractHardlink(hdr *Header) error {
+	name, err := localName(hdr.Name)
+	if err != nil {
+		return err
+	}
+
+	var target string
+	if v := hdr.Data[DataKeyLinkTarget]; 0 < len(v) {
+		target = v[0]
+	}
+	targetName, err := localName(target)
+	if err != nil {
+		return err
+	}
+
+	if err := x.mkdirAll(filepath.Dir(name)); err != nil {
+		return err
+	}
+	if err := x.root.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if err := x.root.Link(targetName, name); err == nil {
+		return nil
+	}
+
+	src, err := x.root.Open(targetName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("cannot copy %s: not a regular file", target)
+	}
+
+	copied := Header{
+		Name: hdr.Name,
+		Size: uint64(info.Size()),
+		Data: hdr.Data,
+	}
+	return x.extractFile(name, &copied, src)
+}
+
+func (x *extractor) mkdirAll(dir string) error {
+	if dir == "." {
+		return nil
+	}
+	if err := x.root.MkdirAll(dir, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+// applyMetadata restores the mode and modification time recorded in data.
+func (x *extractor) applyMetadata(name string, data map[string][]string) error {
+	if v := data[DataKeyMode]; 0 < len(v) {
+		mode, err := ParseMode(v[0])
+		if err != nil {
+			return err
+		}
+		if err := x.root.Chmod(name, mode); err != nil {
+			return err
+		}
+	}
+
+	if v := data[DataKeyModTime]; 0 < len(v) {
+		modTime, err := ParseModTime(v[0])
+		if err != nil {
+			return err
+		}
+		if err := x.root.Chtimes(name, modTime, modTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (x *extractor) start(hdr *Header) {
+	if x.opts.Observer == nil {
+		return
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.opts.Observer.EntryStart(hdr)
+}
+
+func (x *extractor) done(hdr *Header, err error) {
+	if x.opts.Observer == nil {
+		return
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.opts.Observer.EntryDone(hdr, err)
+}
+
+// localName converts an entry name into an OS path relative to the extraction root.
+func localName(name string) (string, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return "", fmt.Errorf("%w: %q", ErrInsecurePath, name)
+	}
+	return filepath.Localize(name)
+}
@@ -0,0 +1,197 @@
+package pitch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// newTestArchive archives fsys, with metadata, and returns the archive bytes.
+func newTestArchive(t *testing.T, fsys fs.FS, root string) []byte {
+	t.Helper()
+
+	buf := bytes.NewBuffer(nil)
+	err := ArchiveFS(&nopCloser{buf}, fsys, root, &ArchiveOptions{
+		Symlinks: SymlinkStore,
+		Metadata: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func extractTestFS() fstest.MapFS {
+	var (
+		modTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		fsys    = fstest.MapFS{
+			"tree/a.txt":     {Data: []byte("AAA"), Mode: 0o600, ModTime: modTime},
+			"tree/bin/run":   {Data: []byte("#!/bin/sh"), Mode: 0o755, ModTime: modTime},
+			"tree/link":      {Data: []byte("bin/run"), Mode: fs.ModeSymlink},
+			"tree/empty.txt": {Data: []byte{}, Mode: 0o644, ModTime: modTime},
+		}
+	)
+	for i := 0; i < 50; i++ {
+		fsys[fmt.Sprintf("tree/many/%02d.txt", i)] = &fstest.MapFile{
+			Data:    []byte(strings.Repeat("x", i*100)),
+			Mode:    0o644,
+			ModTime: modTime,
+		}
+	}
+	return fsys
+}
+
+func checkExtracted(is *is.I, dir string, fsys fstest.MapFS) {
+	for name, file := range fsys {
+		var localName = filepath.Join(dir, filepath.FromSlash(name))
+
+		if file.Mode&fs.ModeSymlink != 0 {
+			target, err := os.Readlink(localName)
+			is.NoErr(err)
+			is.Equal(target, string(file.Data))
+			continue
+		}
+
+		contents, err := os.ReadFile(localName)
+		is.NoErr(err)
+		is.Equal(contents, file.Data)
+
+		info, err := os.Stat(localName)
+		is.NoErr(err)
+		is.Equal(info.Mode().Perm(), file.Mode.Perm())
+		is.True(info.ModTime().Equal(file.ModTime))
+	}
+}
+
+func TestExtract(t *testing.T) {
+	var (
+		is      = is.New(t)
+		fsys    = extractTestFS()
+		archive = newTestArchive(t, fsys, "tree")
+		dir     = t.TempDir()
+		o       = newRecordingObserver()
+	)
+
+	err := Extract(dir, NewReader(bytes.NewReader(archive)), &ExtractOptions{
+		Observer: o,
+	})
+	is.NoErr(err)
+	is.Equal(len(o.errs), len(fsys))
+
+	checkExtracted(is, dir, fsys)
+}
+
+func TestExtractAt(t *testing.T) {
+	var (
+		is      = is.New(t)
+		fsys    = extractTestFS()
+		archive = newTestArchive(t, fsys, "tree")
+		dir     = t.TempDir()
+		o       = newRecordingObserver()
+	)
+
+	toc, err := BuildTableOfContents(archive)
+	is.NoErr(err)
+
+	err = ExtractAt(context.Background(), dir, bytes.NewReader(archive), toc, &ExtractOptions{
+		Concurrency: 8,
+		Observer:    o,
+	})
+	is.NoErr(err)
+
+	is.Equal(len(o.errs), len(fsys))
+	is.Equal(len(o.open), 0)
+
+	checkExtracted(is, dir, fsys)
+}
+
+func TestExtractAt_Canceled(t *testing.T) {
+	var (
+		is      = is.New(t)
+		archive = newTestArchive(t, extractTestFS(), "tree")
+	)
+
+	toc, err := BuildTableOfContents(archive)
+	is.NoErr(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = ExtractAt(ctx, t.TempDir(), bytes.NewReader(archive), toc, nil)
+	is.True(errors.Is(err, context.Canceled))
+}
+
+func TestExtract_InsecurePath(t *testing.T) {
+	var (
+		is = is.New(t)
+
+		tests = []string{
+			"../evil.txt",
+			"a/../../evil.txt",
+			"/etc/evil.txt",
+		}
+	)
+
+	for _, name := range tests {
+		var (
+			buf = bytes.NewBuffer(nil)
+			w   = NewWriter(buf)
+		)
+		_, err := w.WriteHeader(name, 4, nil)
+		is.NoErr(err)
+		_, err = w.Write([]byte("evil"))
+		is.NoErr(err)
+
+		dir := filepath.Join(t.TempDir(), "dst")
+		err = Extract(dir, NewReader(buf), nil)
+		is.True(errors.Is(err, ErrInsecurePath))
+	}
+}
+
+func TestExtract_Dir(t *testing.T) {
+	var (
+		is      = is.New(t)
+		buf     = bytes.NewBuffer(nil)
+		w       = NewWriter(buf)
+		modTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	)
+
+	// the directory is read-only, so its mode has to be applied after its entries are written
+	_, err := w.WriteHeader("ro", 0, map[string][]string{
+		DataKeyType:    {EntryTypeDir},
+		DataKeyMode:    {"0555"},
+		DataKeyModTime: {FormatModTime(modTime)},
+	})
+	is.NoErr(err)
+	_, err = w.WriteHeader("ro/a.txt", 3, nil)
+	is.NoErr(err)
+	_, err = w.Write([]byte("AAA"))
+	is.NoErr(err)
+	_, err = w.WriteHeader("empty", 0, map[string][]string{DataKeyType: {EntryTypeDir}})
+	is.NoErr(err)
+	is.NoErr(w.Close())
+
+	dir := t.TempDir()
+	err = Extract(dir, NewReader(buf), nil)
+	is.NoErr(err)
+	t.Cleanup(func() { os.Chmod(filepath.Join(dir, "ro"), 0o755) })
+
+	info, err := os.Stat(filepath.Join(dir, "ro"))
+	is.NoErr(err)
+	is.Equal(info.Mode().Perm(), fs.FileMode(0o555))
+	is.True(info.ModTime().Equal(modTime))
+
+	info, err = os.Stat(filepath.Join(dir, "empty"))
+	is.NoErr(err)
+	is.True(info.IsDir())
+}
@@ -0,0 +1,103 @@
+package pitch
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// FileServer returns an http.Handler that serves the entries of the archive read by ra,
+// as indexed by toc, under their names. Requests for a directory are served its index.html.
+//
+// Responses honor Range and conditional requests through http.ServeContent.
+// The Content-Type is taken from DataKeyContentType, or else guessed from the extension of the entry.
+// The ETag is the digest held by DataKeyDigest; entries without one get a weak ETag
+// derived from their location within the archive.
+func FileServer(ra io.ReaderAt, toc TableOfContents) http.Handler {
+	return &fileServer{
+		ra:  ra,
+		toc: toc,
+	}
+}
+
+type fileServer struct {
+	ra  io.ReaderAt
+	toc TableOfContents
+}
+
+func (fsrv *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	item := fsrv.lookup(r.URL.Path)
+	if item == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h := w.Header()
+	if v := item.Data[DataKeyContentType]; 0 < len(v) {
+		h.Set("Content-Type", v[0])
+	} else if ctype := mime.TypeByExtension(path.Ext(item.Name)); ctype != "" {
+		h.Set("Content-Type", ctype)
+	}
+	if v := item.Data[DataKeyDigest]; 0 < len(v) {
+		h.Set("ETag", `"`+v[0]+`"`)
+	} else {
+		h.Set("ETag", fmt.Sprintf(`W/"%x-%x"`, item.Start, item.Size))
+	}
+
+	var modTime time.Time
+	if v := item.Data[DataKeyModTime]; 0 < len(v) {
+		modTime, _ = ParseModTime(v[0])
+	}
+
+	http.ServeContent(w, r, item.Name, modTime, item.Content(fsrv.ra))
+}
+
+// lookup returns the file to serve for urlPath, or nil if there is none.
+func (fsrv *fileServer) lookup(urlPath string) *HeaderItem {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" || strings.HasSuffix(urlPath, "/") {
+		name = path.Join(name, "index.html")
+	}
+
+	for range maxSymlinkHops {
+		item, ok := fsrv.toc[name]
+		if !ok {
+			return nil
+		}
+
+		var target string
+		if v := item.Data[DataKeyLinkTarget]; 0 < len(v) {
+			target = v[0]
+		}
+
+		switch EntryType(item.Data) {
+		case "":
+			return item
+		case EntryTypeDir:
+			name = path.Join(name, "index.html")
+		case EntryTypeHardlink:
+			name = target
+		case EntryTypeSymlink:
+			// links may not lead outside of the archive
+			name = path.Join(path.Dir(name), target)
+			if path.IsAbs(target) || !fs.ValidPath(name) {
+				return nil
+			}
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
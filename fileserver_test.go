@@ -0,0 +1,114 @@
+package pitch
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func newFileServerTest(t *testing.T) http.Handler {
+	is := is.New(t)
+
+	archive := writeTestArchive(t,
+		testEntry{name: "index.html", content: "<h1>home</h1>"},
+		testEntry{name: "docs/index.html", content: "<h1>docs</h1>"},
+		testEntry{name: "data.bin", content: "0123456789", data: map[string][]string{
+			DataKeyContentType: {"application/x-test"},
+			DataKeyDigest:      {"sha256:abc"},
+			DataKeyModTime:     {"2024-01-02T03:04:05Z"},
+		}},
+		testEntry{name: "latest", data: map[string][]string{
+			DataKeyType:       {EntryTypeSymlink},
+			DataKeyLinkTarget: {"docs/index.html"},
+		}},
+		testEntry{name: "escape", data: map[string][]string{
+			DataKeyType:       {EntryTypeSymlink},
+			DataKeyLinkTarget: {"../etc/passwd"},
+		}},
+	)
+
+	toc, err := BuildTableOfContents(archive)
+	is.NoErr(err)
+
+	return FileServer(bytes.NewReader(archive), toc)
+}
+
+func TestFileServer(t *testing.T) {
+	var (
+		is = is.New(t)
+		h  = newFileServerTest(t)
+	)
+
+	tests := []struct {
+		path, body, contentType string
+		status                  int
+	}{
+		{path: "/", status: http.StatusOK, body: "<h1>home</h1>", contentType: "text/html; charset=utf-8"},
+		{path: "/docs/", status: http.StatusOK, body: "<h1>docs</h1>"},
+		{path: "/latest", status: http.StatusOK, body: "<h1>docs</h1>"},
+		{path: "/data.bin", status: http.StatusOK, body: "0123456789", contentType: "application/x-test"},
+		{path: "/missing", status: http.StatusNotFound},
+		{path: "/escape", status: http.StatusNotFound},
+		{path: "/../index.html", status: http.StatusOK, body: "<h1>home</h1>"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		is.Equal(rec.Code, tt.status)
+		if tt.status != http.StatusOK {
+			continue
+		}
+		is.Equal(rec.Body.String(), tt.body)
+		if tt.contentType != "" {
+			is.Equal(rec.Header().Get("Content-Type"), tt.contentType)
+		}
+	}
+}
+
+func TestFileServer_RangeAndETag(t *testing.T) {
+	var (
+		is  = is.New(t)
+		srv = httptest.NewServer(newFileServerTest(t))
+	)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/data.bin", nil)
+	is.NoErr(err)
+	req.Header.Set("Range", "bytes=2-5")
+	resp, err := http.DefaultClient.Do(req)
+	is.NoErr(err)
+	body, err := io.ReadAll(resp.Body)
+	is.NoErr(err)
+	resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusPartialContent)
+	is.Equal(string(body), "2345")
+	is.Equal(resp.Header.Get("Content-Length"), "4")
+	is.Equal(resp.Header.Get("ETag"), `"sha256:abc"`)
+	is.Equal(resp.Header.Get("Last-Modified"), "Tue, 02 Jan 2024 03:04:05 GMT")
+
+	req, err = http.NewRequest(http.MethodGet, srv.URL+"/data.bin", nil)
+	is.NoErr(err)
+	req.Header.Set("If-None-Match", `"sha256:abc"`)
+	resp, err = http.DefaultClient.Do(req)
+	is.NoErr(err)
+	resp.Body.Close()
+	is.Equal(resp.StatusCode, http.StatusNotModified)
+
+	resp, err = http.Head(srv.URL + "/index.html")
+	is.NoErr(err)
+	resp.Body.Close()
+	is.Equal(resp.StatusCode, http.StatusOK)
+	is.Equal(resp.Header.Get("Content-Length"), "13")
+	is.True(resp.Header.Get("ETag") != "")
+
+	resp, err = http.Post(srv.URL+"/index.html", "text/plain", nil)
+	is.NoErr(err)
+	resp.Body.Close()
+	is.Equal(resp.StatusCode, http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,318 @@
+package pitch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"time"
+)
+
+// FS is a read-only fs.FS over the entries of an archive, as indexed by a table of contents.
+// Directories are implied by entry names, symlinks are followed by Open and Stat, and
+// hard links behave like the files they link to. Nothing is extracted; file contents
+// are read from the archive as they are needed.
+//
+// FS implements fs.ReadDirFS, fs.StatFS and fs.ReadLinkFS.
+type FS struct {
+	ra    io.ReaderAt
+	files map[string]*fsEntry
+}
+
+var (
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadLinkFS = (*FS)(nil)
+)
+
+// fsEntry is a file or directory of an FS. It implements both fs.FileInfo and fs.DirEntry.
+type fsEntry struct {
+	name    string
+	item    *HeaderItem
+	mode    fs.FileMode
+	modTime time.Time
+	// children holds the base names of the entries of a directory, in order.
+	children []string
+}
+
+// NewFS returns an FS over the archive read by ra, as indexed by toc.
+// It fails if an entry has an invalid name or a hard link names no regular file.
+func NewFS(ra io.ReaderAt, toc TableOfContents) (*FS, error) {
+	fsys := FS{
+		ra: ra,
+		files: map[string]*fsEntry{
+			".": {name: ".", mode: fs.ModeDir | 0o755},
+		},
+	}
+
+	for name, item := range toc {
+		if !fs.ValidPath(name) || name == "." {
+			return nil, fmt.Errorf("%w: %q", ErrInsecurePath, name)
+		}
+
+		e, err := newFSEntry(item)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", name, err)
+		}
+		if err := fsys.add(e); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, e := range fsys.files {
+		if e.item == nil || EntryType(e.item.Data) != EntryTypeHardlink {
+			continue
+		}
+
+		var target string
+		if v := e.item.Data[DataKeyLinkTarget]; 0 < len(v) {
+			target = v[0]
+		}
+		linked, ok := toc[target]
+		if !ok || EntryType(linked.Data) != "" {
+			return nil, fmt.Errorf("error reading %s: hard link to missing file %q", name, target)
+		}
+		e.item = linked
+	}
+
+	for _, e := range fsys.files {
+		slices.Sort(e.children)
+	}
+
+	return &fsys, nil
+}
+
+func newFSEntry(item *HeaderItem) (*fsEntry, error) {
+	e := fsEntry{
+		name: item.Name,
+		item: item,
+		mode: 0o644,
+	}
+
+	switch typ := EntryType(item.Data); typ {
+	case "", EntryTypeHardlink:
+	case EntryTypeDir:
+		e.mode = fs.ModeDir | 0o755
+	case EntryTypeSymlink:
+		if v := item.Data[DataKeyLinkTarget]; len(v) == 0 || v[0] == "" {
+			return nil, errors.New("missing link target")
+		}
+		e.mode = fs.ModeSymlink | 0o777
+	default:
+		return nil, fmt.Errorf("unsupported entry type %q", typ)
+	}
+
+	if v := item.Data[DataKeyMode]; 0 < len(v) {
+		perm, err := ParseMode(v[0])
+		if err != nil {
+			return nil, err
+		}
+		e.mode = e.mode.Type() | perm
+	}
+	if v := item.Data[DataKeyModTime]; 0 < len(v) {
+		modTime, err := ParseModTime(v[0])
+		if err != nil {
+			return nil, err
+		}
+		e.modTime = modTime
+	}
+
+	return &e, nil
+}
+
+// add adds e and every directory it is implied to be in.
+func (fsys *FS) add(e *fsEntry) error {
+	if existing, ok := fsys.files[e.name]; ok {
+		// an implied directory gets its details from the entry that makes it explicit
+		if existing.item == nil && e.mode.IsDir() {
+			e.children = existing.children
+			fsys.files[e.name] = e
+			return nil
+		}
+		return fmt.Errorf("error reading %s: duplicate entry", e.name)
+	}
+	fsys.files[e.name] = e
+
+	for name := e.name; name != "."; {
+		dir := path.Dir(name)
+		parent, ok := fsys.files[dir]
+		if !ok {
+			parent = &fsEntry{
+				name: dir,
+				mode: fs.ModeDir | 0o755,
+			}
+			fsys.files[dir] = parent
+		} else if !parent.mode.IsDir() {
+			return fmt.Errorf("error reading %s: %s is not a directory", e.name, dir)
+		}
+
+		parent.children = append(parent.children, path.Base(name))
+		if ok {
+			break
+		}
+		name = dir
+	}
+
+	return nil
+}
+
+// Open implements fs.FS.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	e, err := fsys.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.mode.IsDir() {
+		return &fsDir{fsys: fsys, e: e}, nil
+	}
+	return &fsFile{
+		e:             e,
+		SectionReader: e.item.Content(fsys.ra),
+	}, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := fsys.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return fsys.dirEntries(e), nil
+}
+
+// Stat implements fs.StatFS.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	e, err := fsys.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Lstat implements fs.ReadLinkFS.
+func (fsys *FS) Lstat(name string) (fs.FileInfo, error) {
+	e, err := fsys.lookup("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ReadLink implements fs.ReadLinkFS.
+func (fsys *FS) ReadLink(name string) (string, error) {
+	e, err := fsys.lookup("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if e.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return e.item.Data[DataKeyLinkTarget][0], nil
+}
+
+// lookup returns the entry named name without following symlinks in its last element.
+func (fsys *FS) lookup(op, name string) (*fsEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	dir, err := resolvePath(fsys, path.Dir(name), "")
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	e, ok := fsys.files[path.Join(dir, path.Base(name))]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+// resolve returns the entry named name, following every symlink.
+func (fsys *FS) resolve(op, name string) (*fsEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	resolved, err := resolvePath(fsys, name, "")
+	if err != nil {
+		var pe *fs.PathError
+		if errors.As(err, &pe) {
+			err = pe.Err
+		}
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return fsys.files[resolved], nil
+}
+
+func (fsys *FS) dirEntries(e *fsEntry) []fs.DirEntry {
+	entries := make([]fs.DirEntry, len(e.children))
+	for i, child := range e.children {
+		entries[i] = fsys.files[path.Join(e.name, child)]
+	}
+	return entries
+}
+
+func (e *fsEntry) Name() string               { return path.Base(e.name) }
+func (e *fsEntry) Mode() fs.FileMode          { return e.mode }
+func (e *fsEntry) ModTime() time.Time         { return e.modTime }
+func (e *fsEntry) IsDir() bool                { return e.mode.IsDir() }
+func (e *fsEntry) Type() fs.FileMode          { return e.mode.Type() }
+func (e *fsEntry) Info() (fs.FileInfo, error) { return e, nil }
+func (e *fsEntry) String() string             { return fs.FormatFileInfo(e) }
+
+// Size returns the size of the content of a file, or the length of the target of a symlink.
+func (e *fsEntry) Size() int64 {
+	switch {
+	case e.mode.IsRegular():
+		return int64(e.item.Size)
+	case e.mode&fs.ModeSymlink != 0:
+		return int64(len(e.item.Data[DataKeyLinkTarget][0]))
+	}
+	return 0
+}
+
+// Sys returns the *HeaderItem of the entry, which is nil for implied directories.
+func (e *fsEntry) Sys() any { return e.item }
+
+// fsFile is an open regular file of an FS.
+type fsFile struct {
+	e *fsEntry
+	*io.SectionReader
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.e, nil }
+func (f *fsFile) Close() error               { return nil }
+
+// fsDir is an open directory of an FS.
+type fsDir struct {
+	fsys   *FS
+	e      *fsEntry
+	offset int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.e, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.e.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries := d.fsys.dirEntries(d.e)[d.offset:]
+	if 0 < n && n < len(entries) {
+		entries = entries[:n]
+	}
+	if 0 < n && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	d.offset += len(entries)
+	return entries, nil
+}
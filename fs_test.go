@@ -0,0 +1,108 @@
+package pitch
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+func newTestFS(t *testing.T, fsys fs.FS, root string) *FS {
+	t.Helper()
+
+	archive := newTestArchive(t, fsys, root)
+	toc, err := BuildTableOfContents(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	afs, err := NewFS(bytes.NewReader(archive), toc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return afs
+}
+
+func TestFS(t *testing.T) {
+	var (
+		is   = is.New(t)
+		fsys = extractTestFS()
+		afs  = newTestFS(t, fsys, "tree")
+	)
+
+	err := fstest.TestFS(afs, "tree/a.txt", "tree/bin/run", "tree/link", "tree/many/07.txt")
+	is.NoErr(err)
+
+	// the symlink is followed
+	contents, err := fs.ReadFile(afs, "tree/link")
+	is.NoErr(err)
+	is.Equal(contents, fsys["tree/bin/run"].Data)
+
+	info, err := fs.Lstat(afs, "tree/link")
+	is.NoErr(err)
+	is.True(info.Mode()&fs.ModeSymlink != 0)
+
+	target, err := fs.ReadLink(afs, "tree/link")
+	is.NoErr(err)
+	is.Equal(target, "bin/run")
+
+	info, err = fs.Stat(afs, "tree/a.txt")
+	is.NoErr(err)
+	is.Equal(info.Mode(), fsys["tree/a.txt"].Mode)
+	is.True(info.ModTime().Equal(fsys["tree/a.txt"].ModTime))
+
+	_, err = afs.Open("tree/missing")
+	is.True(errors.Is(err, fs.ErrNotExist))
+}
+
+func TestFS_Links(t *testing.T) {
+	var (
+		is  = is.New(t)
+		buf = bytes.NewBuffer(nil)
+		w   = NewWriter(buf)
+	)
+
+	_, err := w.WriteHeader("a.txt", 3, nil)
+	is.NoErr(err)
+	_, err = w.Write([]byte("AAA"))
+	is.NoErr(err)
+	_, err = w.WriteHeader("hard.txt", 0, map[string][]string{
+		DataKeyType:       {EntryTypeHardlink},
+		DataKeyLinkTarget: {"a.txt"},
+	})
+	is.NoErr(err)
+	_, err = w.WriteHeader("empty", 0, map[string][]string{
+		DataKeyType: {EntryTypeDir},
+		DataKeyMode: {"0700"},
+	})
+	is.NoErr(err)
+	_, err = w.WriteHeader("escape", 0, map[string][]string{
+		DataKeyType:       {EntryTypeSymlink},
+		DataKeyLinkTarget: {"../a.txt"},
+	})
+	is.NoErr(err)
+	is.NoErr(w.Close())
+
+	toc, err := BuildTableOfContents(buf.Bytes())
+	is.NoErr(err)
+	afs, err := NewFS(bytes.NewReader(buf.Bytes()), toc)
+	is.NoErr(err)
+
+	contents, err := fs.ReadFile(afs, "hard.txt")
+	is.NoErr(err)
+	is.Equal(string(contents), "AAA")
+
+	info, err := fs.Stat(afs, "empty")
+	is.NoErr(err)
+	is.Equal(info.Mode(), fs.ModeDir|0o700)
+
+	entries, err := fs.ReadDir(afs, ".")
+	is.NoErr(err)
+	is.Equal(len(entries), 4)
+
+	_, err = afs.Open("escape")
+	is.True(err != nil)
+}
@@ -1,8 +1,5 @@
 module github.com/raphaelreyna/pitch
 
-go 1.21
+go 1.25.0
 
-require (
-	github.com/matryer/is v1.4.0
-	golang.org/x/exp v0.0.0-20230905200255-921286631fa9
-)
+require github.com/matryer/is v1.4.0
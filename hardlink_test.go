@@ -0,0 +1,68 @@
+//go:build unix
+
+package pitch
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestArchiveDir_Hardlinks(t *testing.T) {
+	var (
+		is  = is.New(t)
+		dir = t.TempDir()
+		src = filepath.Join(dir, "src")
+	)
+
+	is.NoErr(os.MkdirAll(filepath.Join(src, "sub"), 0o755))
+	is.NoErr(os.WriteFile(filepath.Join(src, "a.txt"), bytes.Repeat([]byte("A"), 4096), 0o644))
+	is.NoErr(os.Link(filepath.Join(src, "a.txt"), filepath.Join(src, "b.txt")))
+	is.NoErr(os.Link(filepath.Join(src, "a.txt"), filepath.Join(src, "sub", "c.txt")))
+	is.NoErr(os.WriteFile(filepath.Join(src, "other.txt"), []byte("other"), 0o644))
+
+	buf := bytes.NewBuffer(nil)
+	err := ArchiveDirWithOptions(&nopCloser{buf}, src, &ArchiveOptions{
+		Metadata:  true,
+		Hardlinks: true,
+	})
+	is.NoErr(err)
+	is.True(buf.Len() < 2*4096) // the content is stored once
+	archive := buf.Bytes()
+
+	_, hdrs, err := readArchive(NewReader(bytes.NewReader(archive)))
+	is.NoErr(err)
+	is.Equal(hdrs["src/a.txt"].Size, uint64(4096))
+	for _, name := range []string{"src/b.txt", "src/sub/c.txt"} {
+		is.Equal(EntryType(hdrs[name].Data), EntryTypeHardlink)
+		is.Equal(hdrs[name].Data[DataKeyLinkTarget], []string{"src/a.txt"})
+	}
+	is.Equal(EntryType(hdrs["src/other.txt"].Data), "")
+
+	toc, err := BuildTableOfContents(archive)
+	is.NoErr(err)
+
+	extractors := map[string]func(dst string) error{
+		"Extract": func(dst string) error {
+			return Extract(dst, NewReader(bytes.NewReader(archive)), nil)
+		},
+		"ExtractAt": func(dst string) error {
+			return ExtractAt(t.Context(), dst, bytes.NewReader(archive), toc, &ExtractOptions{Concurrency: 4})
+		},
+	}
+	for _, extract := range extractors {
+		out := t.TempDir()
+		is.NoErr(extract(out))
+
+		a, err := os.Stat(filepath.Join(out, "src", "a.txt"))
+		is.NoErr(err)
+		for _, name := range []string{"b.txt", filepath.Join("sub", "c.txt")} {
+			info, err := os.Stat(filepath.Join(out, "src", name))
+			is.NoErr(err)
+			is.True(os.SameFile(a, info))
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"maps"
+	"slices"
 )
 
 type SizeType uint8
@@ -116,7 +118,6 @@ func DecodeHeader(r io.Reader) (*Header, error) {
 	}
 
 	name := ""
-	value := ""
 
 	for done := false; !done; {
 		buf.Reset()
@@ -132,11 +133,6 @@ func DecodeHeader(r io.Reader) (*Header, error) {
 			done = true
 			h.Size = s.Value
 		case DataNameSize:
-			if name != "" {
-				h.Data[name] = append(h.Data[name], value)
-			}
-			name = ""
-
 			buf.Reset()
 			resizeBuffer(buf, s.Value)
 			data := buf.Bytes()[:s.Value]
@@ -144,6 +140,10 @@ func DecodeHeader(r io.Reader) (*Header, error) {
 				return nil, fmt.Errorf("error reading header byte: %w", err)
 			}
 			name = string(data)
+			// a key may be encoded without any values
+			if _, ok := h.Data[name]; !ok {
+				h.Data[name] = nil
+			}
 		case DataValueSize:
 			if name == "" {
 				return nil, fmt.Errorf("unexpected value size")
@@ -218,17 +218,12 @@ func EncodedHeaderSize(name string, size uint64, data map[string][]string) uint6
 	)
 
 	for k, v := range data {
+		// each key is encoded once, followed by each of its values
 		optionalNameSize := uint64(len(k))
-		optionalNameSizeSize := uint64(ByteCount(optionalNameSize))
-		ons := optionalNameSizeSize + optionalNameSize
+		dataSize += uint64(ByteCount(optionalNameSize)) + optionalNameSize
 		for _, s := range v {
-			// if a key has multiple values, then it was encoded multiple times
-			dataSize += ons
-			dataSize += uint64(len(s))
-		}
-		// if a key has no values, then it was encoded once
-		if len(v) == 0 {
-			dataSize += ons
+			valueSize := uint64(len(s))
+			dataSize += uint64(ByteCount(valueSize)) + valueSize
 		}
 	}
 
@@ -236,6 +231,7 @@ func EncodedHeaderSize(name string, size uint64, data map[string][]string) uint6
 }
 
 // EncodeHeader encodes the given header into a byte slice.
+// The encoding is deterministic: data keys are encoded in sorted order.
 func EncodeHeader(h Header) []byte {
 	var (
 		buf      = bytes.NewBuffer(nil)
@@ -244,8 +240,17 @@ func EncodeHeader(h Header) []byte {
 
 	buf.Write(EncodeSize(NameSize, nameSize))
 	buf.WriteString(h.Name)
+	encodeData(buf, h.Data)
+	buf.Write(EncodeSize(ContentSize, h.Size))
+
+	return buf.Bytes()
+}
 
-	for k, v := range h.Data {
+// encodeData writes the encoding of the header data data to buf.
+func encodeData(buf *bytes.Buffer, data map[string][]string) {
+	// keys are written in sorted order so that equal headers always encode to the same bytes
+	for _, k := range slices.Sorted(maps.Keys(data)) {
+		v := data[k]
 		optionalNameSize := uint64(len(k))
 		buf.Write(EncodeSize(DataNameSize, optionalNameSize))
 		buf.WriteString(k)
@@ -254,8 +259,4 @@ func EncodeHeader(h Header) []byte {
 			buf.WriteString(s)
 		}
 	}
-
-	buf.Write(EncodeSize(ContentSize, h.Size))
-
-	return buf.Bytes()
 }
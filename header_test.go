@@ -0,0 +1,84 @@
+package pitch
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+// baselineArchive was written by the original Writer. It holds a.txt with
+// the data {Content-Type: [text/plain], Tags: [x, y]} and contents "hello",
+// and b.txt with a valueless Empty key and contents "hi".
+var baselineArchive = []byte{
+	0x0b, 0x61, 0x2e, 0x74, 0x78, 0x74, 0x99, 0x43, 0x6f, 0x6e, 0x74, 0x65,
+	0x6e, 0x74, 0x2d, 0x54, 0x79, 0x70, 0x65, 0xd5, 0x74, 0x65, 0x78, 0x74,
+	0x2f, 0x70, 0x6c, 0x61, 0x69, 0x6e, 0x89, 0x54, 0x61, 0x67, 0x73, 0xc3,
+	0x78, 0xc3, 0x79, 0x4b, 0x68, 0x65, 0x6c, 0x6c, 0x6f, 0x0b, 0x62, 0x2e,
+	0x74, 0x78, 0x74, 0x8b, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x45, 0x68, 0x69,
+}
+
+func TestDecodeHeader_BaselineArchive(t *testing.T) {
+	is := is.New(t)
+
+	toc, err := BuildTableOfContents(baselineArchive)
+	is.NoErr(err)
+	is.Equal(len(toc), 2)
+
+	a := toc["a.txt"]
+	is.True(a != nil)
+	is.Equal(a.Data, map[string][]string{
+		"Content-Type": {"text/plain"},
+		"Tags":         {"x", "y"},
+	})
+	is.Equal(string(baselineArchive[a.Start:a.End]), "hello")
+
+	b := toc["b.txt"]
+	is.True(b != nil)
+	is.Equal(b.Data, map[string][]string{"Empty": nil})
+	is.Equal(string(baselineArchive[b.Start:b.End]), "hi")
+}
+
+func TestEncodedHeaderSize(t *testing.T) {
+	is := is.New(t)
+
+	hdr := Header{
+		Name: "a.txt",
+		Size: 300,
+		Data: map[string][]string{
+			"a":     {"1", "11"},
+			"Empty": nil,
+			"long":  {string(bytes.Repeat([]byte("x"), 100))},
+		},
+	}
+	is.Equal(EncodedHeaderSize(hdr.Name, hdr.Size, hdr.Data), uint64(len(EncodeHeader(hdr))))
+}
+
+func TestEncodeHeader_Deterministic(t *testing.T) {
+	var (
+		is  = is.New(t)
+		hdr = Header{
+			Name: "a.txt",
+			Size: 3,
+			Data: map[string][]string{
+				"b":            {"2"},
+				"a":            {"1", "11"},
+				"Content-Type": {"text/plain"},
+				"z":            {"26"},
+				"m":            {"13"},
+			},
+		}
+		expected = EncodeHeader(hdr)
+	)
+
+	for i := 0; i < 32; i++ {
+		is.Equal(EncodeHeader(hdr), expected)
+	}
+
+	decoded, err := DecodeHeader(bytes.NewReader(expected))
+	is.NoErr(err)
+	is.Equal(decoded.Name, hdr.Name)
+	is.Equal(decoded.Size, hdr.Size)
+	is.Equal(decoded.Data, hdr.Data)
+	is.Equal(uint64(len(expected)), EncodedHeaderSize(hdr.Name, hdr.Size, hdr.Data))
+}
@@ -0,0 +1,363 @@
+package pitch
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+	"sort"
+)
+
+// An index is an optional section written after the end of an archive, see Writer.EnableIndex.
+// Readers stop at the end marker in front of it, so archives with an index read like any other.
+//
+// The section holds a table of name records sorted by name, a table of data records sorted by
+// key, value and entry, and a pool of the strings both refer to. A fixed size footer at the very
+// end of the archive locates the section:
+//
+//	name record:  pool offset (8) | name length (4) | header offset (8)
+//	data record:  key pool offset (8) | key length (4) | value pool offset (8) | value length (4) | name record (8)
+//	footer:       "PITCHIDX" | section offset (8) | name records (8) | data records (8)
+//
+// All integers are big endian.
+const (
+	indexNameRecordSize = 20
+	indexDataRecordSize = 32
+	indexFooterSize     = 32
+	indexMagic          = "PITCHIDX"
+)
+
+// ErrNoIndex is returned by OpenIndex for archives without an index.
+var ErrNoIndex = errors.New("pitch: archive has no index")
+
+// indexBuilder collects the entries written by a Writer with an index.
+type indexBuilder struct {
+	keys    []string
+	entries []indexedEntry
+}
+
+type indexedEntry struct {
+	name   string
+	offset int64
+	data   map[string][]string
+}
+
+// EnableIndex makes the writer append an index of the entries it writes when it is closed,
+// so that entries can be looked up by name with a few reads, without building a table of contents.
+// Values of the given header data keys are indexed too, see Index.LookupData.
+// Only entries written after EnableIndex is called are indexed.
+func (wtr *Writer) EnableIndex(dataKeys ...string) {
+	wtr.index = &indexBuilder{
+		keys: slices.Clone(dataKeys),
+	}
+}
+
+// add records the entry whose header was written at offset.
+func (ib *indexBuilder) add(name string, offset int64, data map[string][]string) {
+	var indexed map[string][]string
+	for _, k := range ib.keys {
+		if v, ok := data[k]; ok {
+			if indexed == nil {
+				indexed = make(map[string][]string)
+			}
+			indexed[k] = v
+		}
+	}
+	ib.entries = append(ib.entries, indexedEntry{
+		name:   name,
+		offset: offset,
+		data:   indexed,
+	})
+}
+
+type indexDataRecord struct {
+	key, value string
+	entry      uint64
+}
+
+// encode returns the index section, with its footer, for a section starting at sectionOffset.
+func (ib *indexBuilder) encode(sectionOffset int64) []byte {
+	// later entries win, like they do in tables of contents
+	latest := make(map[string]indexedEntry, len(ib.entries))
+	for _, e := range ib.entries {
+		latest[e.name] = e
+	}
+	entries := slices.SortedFunc(maps.Values(latest), func(a, b indexedEntry) int {
+		return cmp.Compare(a.name, b.name)
+	})
+
+	var records []indexDataRecord
+	for i, e := range entries {
+		for k, values := range e.data {
+			for _, v := range slices.Compact(slices.Sorted(slices.Values(values))) {
+				records = append(records, indexDataRecord{key: k, value: v, entry: uint64(i)})
+			}
+		}
+	}
+	slices.SortFunc(records, func(a, b indexDataRecord) int {
+		return cmp.Or(cmp.Compare(a.key, b.key), cmp.Compare(a.value, b.value), cmp.Compare(a.entry, b.entry))
+	})
+
+	var (
+		tables  = bytes.NewBuffer(nil)
+		pool    = bytes.NewBuffer(nil)
+		offsets = make(map[string]uint64)
+		intern  = func(s string) uint64 {
+			off, ok := offsets[s]
+			if !ok {
+				off = uint64(pool.Len())
+				offsets[s] = off
+				pool.WriteString(s)
+			}
+			return off
+		}
+	)
+	for _, e := range entries {
+		tables.Write(binary.BigEndian.AppendUint64(nil, intern(e.name)))
+		tables.Write(binary.BigEndian.AppendUint32(nil, uint32(len(e.name))))
+		tables.Write(binary.BigEndian.AppendUint64(nil, uint64(e.offset)))
+	}
+	for _, r := range records {
+		tables.Write(binary.BigEndian.AppendUint64(nil, intern(r.key)))
+		tables.Write(binary.BigEndian.AppendUint32(nil, uint32(len(r.key))))
+		tables.Write(binary.BigEndian.AppendUint64(nil, intern(r.value)))
+		tables.Write(binary.BigEndian.AppendUint32(nil, uint32(len(r.value))))
+		tables.Write(binary.BigEndian.AppendUint64(nil, r.entry))
+	}
+
+	section := append(tables.Bytes(), pool.Bytes()...)
+	section = append(section, indexMagic...)
+	section = binary.BigEndian.AppendUint64(section, uint64(sectionOffset))
+	section = binary.BigEndian.AppendUint64(section, uint64(len(entries)))
+	section = binary.BigEndian.AppendUint64(section, uint64(len(records)))
+	return section
+}
+
+// Index looks entries of an archive up through the index written at its end, see Writer.EnableIndex.
+// Every lookup reads only the parts of the archive it needs, so an Index suits huge or remote archives
+// (see HTTPReaderAt) for which building a table of contents is too slow.
+// It is safe for concurrent use if the underlying io.ReaderAt is.
+type Index struct {
+	ra io.ReaderAt
+	// end is the end of the entries of the archive, where the index section starts.
+	end        int64
+	names      int64
+	nameCount  int64
+	data       int64
+	dataCount  int64
+	pool       int64
+	poolLength int64
+}
+
+// OpenIndex returns the Index of the archive of the given size read from ra.
+// It returns ErrNoIndex if the archive has none.
+func OpenIndex(ra io.ReaderAt, size int64) (*Index, error) {
+	if size < indexFooterSize {
+		return nil, ErrNoIndex
+	}
+
+	footer := make([]byte, indexFooterSize)
+	if _, err := ra.ReadAt(footer, size-indexFooterSize); err != nil {
+		return nil, fmt.Errorf("error reading index footer: %w", err)
+	}
+	if string(footer[:len(indexMagic)]) != indexMagic {
+		return nil, ErrNoIndex
+	}
+
+	var (
+		fields     = footer[len(indexMagic):]
+		section    = binary.BigEndian.Uint64(fields)
+		nameCount  = binary.BigEndian.Uint64(fields[8:])
+		dataCount  = binary.BigEndian.Uint64(fields[16:])
+		footerAt   = uint64(size - indexFooterSize)
+		tablesSize = nameCount*indexNameRecordSize + dataCount*indexDataRecordSize
+	)
+	if section == 0 || footerAt < section ||
+		footerAt/indexNameRecordSize < nameCount || footerAt/indexDataRecordSize < dataCount ||
+		footerAt-section < tablesSize {
+		return nil, errors.New("pitch: corrupt index footer")
+	}
+
+	ix := Index{
+		ra:        ra,
+		end:       int64(section),
+		names:     int64(section),
+		nameCount: int64(nameCount),
+		dataCount: int64(dataCount),
+	}
+	ix.data = ix.names + ix.nameCount*indexNameRecordSize
+	ix.pool = ix.data + ix.dataCount*indexDataRecordSize
+	ix.poolLength = int64(footerAt) - ix.pool
+	return &ix, nil
+}
+
+// Len returns the number of entries in the index.
+func (ix *Index) Len() int {
+	return int(ix.nameCount)
+}
+
+// Lookup returns the item of the entry named name, resolved like the items of a table of contents.
+// The error wraps fs.ErrNotExist if there is no such entry.
+func (ix *Index) Lookup(name string) (*HeaderItem, error) {
+	var searchErr error
+	i := sort.Search(int(ix.nameCount), func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+		n, _, err := ix.nameRecord(int64(i))
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return name <= n
+	})
+	if searchErr != nil {
+		return nil, searchErr
+	}
+
+	if i < int(ix.nameCount) {
+		n, offset, err := ix.nameRecord(int64(i))
+		if err != nil {
+			return nil, err
+		}
+		if n == name {
+			return ix.item(n, offset)
+		}
+	}
+	return nil, fmt.Errorf("error looking up %s: %w", name, fs.ErrNotExist)
+}
+
+// LookupData returns the items of the entries with value among the values of the header data key,
+// sorted by name. Keys that were not indexed have no entries.
+func (ix *Index) LookupData(key, value string) ([]*HeaderItem, error) {
+	var searchErr error
+	first := sort.Search(int(ix.dataCount), func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+		k, v, _, err := ix.dataRecord(int64(i))
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return cmp.Or(cmp.Compare(k, key), cmp.Compare(v, value)) >= 0
+	})
+	if searchErr != nil {
+		return nil, searchErr
+	}
+
+	var items []*HeaderItem
+	for i := int64(first); i < ix.dataCount; i++ {
+		k, v, entry, err := ix.dataRecord(i)
+		if err != nil {
+			return nil, err
+		}
+		if k != key || v != value {
+			break
+		}
+
+		name, offset, err := ix.nameRecord(entry)
+		if err != nil {
+			return nil, err
+		}
+		item, err := ix.item(name, offset)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (ix *Index) nameRecord(i int64) (string, int64, error) {
+	if i < 0 || ix.nameCount <= i {
+		return "", 0, fmt.Errorf("pitch: corrupt index: no name record %d", i)
+	}
+
+	rec := make([]byte, indexNameRecordSize)
+	if _, err := ix.ra.ReadAt(rec, ix.names+i*indexNameRecordSize); err != nil {
+		return "", 0, fmt.Errorf("error reading index: %w", err)
+	}
+	name, err := ix.poolString(binary.BigEndian.Uint64(rec), binary.BigEndian.Uint32(rec[8:]))
+	if err != nil {
+		return "", 0, err
+	}
+	offset := binary.BigEndian.Uint64(rec[12:])
+	if uint64(ix.end) <= offset {
+		return "", 0, fmt.Errorf("pitch: corrupt index: %s is out of bounds", name)
+	}
+	return name, int64(offset), nil
+}
+
+func (ix *Index) dataRecord(i int64) (string, string, int64, error) {
+	rec := make([]byte, indexDataRecordSize)
+	if _, err := ix.ra.ReadAt(rec, ix.data+i*indexDataRecordSize); err != nil {
+		return "", "", 0, fmt.Errorf("error reading index: %w", err)
+	}
+	key, err := ix.poolString(binary.BigEndian.Uint64(rec), binary.BigEndian.Uint32(rec[8:]))
+	if err != nil {
+		return "", "", 0, err
+	}
+	value, err := ix.poolString(binary.BigEndian.Uint64(rec[12:]), binary.BigEndian.Uint32(rec[20:]))
+	if err != nil {
+		return "", "", 0, err
+	}
+	return key, value, int64(binary.BigEndian.Uint64(rec[24:])), nil
+}
+
+func (ix *Index) poolString(offset uint64, length uint32) (string, error) {
+	if uint64(ix.poolLength) < offset || uint64(ix.poolLength)-offset < uint64(length) {
+		return "", errors.New("pitch: corrupt index: string out of bounds")
+	}
+
+	b := make([]byte, length)
+	if _, err := ix.ra.ReadAt(b, ix.pool+int64(offset)); err != nil {
+		return "", fmt.Errorf("error reading index: %w", err)
+	}
+	return string(b), nil
+}
+
+// item returns the item of the entry whose header is at offset.
+func (ix *Index) item(name string, offset int64) (*HeaderItem, error) {
+	hdr, err := readHeaderAt(ix.ra, offset, ix.end)
+	if err != nil {
+		return nil, fmt.Errorf("error reading header of %s: %w", name, err)
+	}
+	if hdr.Name != name {
+		return nil, fmt.Errorf("pitch: corrupt index: found %s where %s should be", hdr.Name, name)
+	}
+
+	item := HeaderItem{
+		Name:  hdr.Name,
+		Size:  hdr.Size,
+		Data:  hdr.Data,
+		Start: offset + int64(EncodedHeaderSize(hdr.Name, hdr.Size, hdr.Data)),
+	}
+	item.End = item.Start + int64(hdr.Size)
+	if err := resolveItem(&item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// headerReadAhead is the number of bytes read at once when decoding a single header,
+// which is enough for most headers.
+const headerReadAhead = 512
+
+// readHeaderAt decodes the header at offset, which lies before end.
+func readHeaderAt(ra io.ReaderAt, offset, end int64) (*Header, error) {
+	buf := make([]byte, min(headerReadAhead, end-offset))
+	n, err := ra.ReadAt(buf, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if hdr, err := DecodeHeader(bytes.NewReader(buf[:n])); err == nil {
+		return hdr, nil
+	}
+	return DecodeHeader(io.NewSectionReader(ra, offset, end-offset))
+}
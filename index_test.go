@@ -0,0 +1,183 @@
+package pitch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func newIndexedArchive(t *testing.T) []byte {
+	var (
+		is  = is.New(t)
+		buf = bytes.NewBuffer(nil)
+		w   = NewWriter(buf)
+	)
+	is.NoErr(w.SetArchiveData(map[string][]string{"Creator": {"test"}}))
+	w.EnableIndex("Owner", DataKeyContentType)
+
+	for i := range 50 {
+		writeTestEntries(t, w, testEntry{name: fmt.Sprintf("files/%02d.txt", i), content: fmt.Sprint(i), data: map[string][]string{
+			"Owner": {[]string{"web", "db"}[i%2], "all", "all"},
+		}})
+	}
+	writeTestEntries(t, w, testEntry{name: "site/index.html", content: "<html>", data: map[string][]string{DataKeyContentType: {"text/html"}}})
+	offset := w.Offset()
+	writeTestEntries(t, w, testEntry{name: "site/copy.txt", content: "original"})
+	_, err := w.WriteReference("site/ref.txt", offset+int64(EncodedHeaderSize("site/copy.txt", 8, nil)), 8, map[string][]string{"Owner": {"ref"}})
+	is.NoErr(err)
+	// later entries win
+	writeTestEntries(t, w, testEntry{name: "site/copy.txt", content: "replaced"})
+	is.NoErr(w.Close())
+
+	return buf.Bytes()
+}
+
+func TestIndex_Lookup(t *testing.T) {
+	var (
+		is      = is.New(t)
+		archive = newIndexedArchive(t)
+		ra      = bytes.NewReader(archive)
+	)
+
+	ix, err := OpenIndex(ra, int64(len(archive)))
+	is.NoErr(err)
+	is.Equal(ix.Len(), 53)
+
+	toc, err := BuildTableOfContents(archive)
+	is.NoErr(err)
+	is.Equal(len(toc), 53)
+	for name, want := range toc {
+		item, err := ix.Lookup(name)
+		is.NoErr(err)
+		is.Equal(item, want)
+	}
+
+	item, err := ix.Lookup("site/ref.txt")
+	is.NoErr(err)
+	content, err := io.ReadAll(item.Content(ra))
+	is.NoErr(err)
+	is.Equal(string(content), "original")
+
+	item, err = ix.Lookup("site/copy.txt")
+	is.NoErr(err)
+	content, err = io.ReadAll(item.Content(ra))
+	is.NoErr(err)
+	is.Equal(string(content), "replaced")
+
+	for _, name := range []string{"", "a", "files", "files/50.txt", "zzz", ArchiveDataName} {
+		_, err = ix.Lookup(name)
+		is.True(errors.Is(err, fs.ErrNotExist))
+	}
+}
+
+func TestIndex_LookupData(t *testing.T) {
+	var (
+		is      = is.New(t)
+		archive = newIndexedArchive(t)
+	)
+
+	ix, err := OpenIndex(bytes.NewReader(archive), int64(len(archive)))
+	is.NoErr(err)
+
+	items, err := ix.LookupData("Owner", "db")
+	is.NoErr(err)
+	is.Equal(len(items), 25)
+	is.Equal(items[0].Name, "files/01.txt")
+	is.Equal(items[24].Name, "files/49.txt")
+
+	items, err = ix.LookupData("Owner", "all")
+	is.NoErr(err)
+	is.Equal(len(items), 50) // once per entry
+
+	items, err = ix.LookupData(DataKeyContentType, "text/html")
+	is.NoErr(err)
+	is.Equal(len(items), 1)
+	is.Equal(items[0].Name, "site/index.html")
+
+	items, err = ix.LookupData("Owner", "ref")
+	is.NoErr(err)
+	is.Equal(len(items), 1)
+	is.Equal(items[0].Size, uint64(8))
+
+	items, err = ix.LookupData("Owner", "nobody")
+	is.NoErr(err)
+	is.Equal(len(items), 0)
+	items, err = ix.LookupData("Unindexed", "x")
+	is.NoErr(err)
+	is.Equal(len(items), 0)
+}
+
+func TestIndex_Readers(t *testing.T) {
+	var (
+		is      = is.New(t)
+		archive = newIndexedArchive(t)
+	)
+
+	// readers stop in front of the index
+	_, hdrs, err := readArchive(NewReader(bytes.NewReader(archive)))
+	is.NoErr(err)
+	is.Equal(len(hdrs), 53)
+}
+
+func TestIndex_None(t *testing.T) {
+	var (
+		is  = is.New(t)
+		buf = bytes.NewBuffer(nil)
+		w   = NewWriter(buf)
+	)
+	_, err := w.WriteHeader("a.txt", 0, nil)
+	is.NoErr(err)
+	is.NoErr(w.Close())
+
+	_, err = OpenIndex(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	is.True(errors.Is(err, ErrNoIndex))
+
+	// corrupt footers are not trusted
+	corrupt := append(bytes.Repeat([]byte{0}, 8), indexMagic...)
+	corrupt = append(corrupt, bytes.Repeat([]byte{0xff}, 24)...)
+	_, err = OpenIndex(bytes.NewReader(corrupt), int64(len(corrupt)))
+	is.True(err != nil)
+}
+
+func TestIndex_ShortWrite(t *testing.T) {
+	var (
+		is = is.New(t)
+		w  = NewWriter(io.Discard)
+	)
+	w.EnableIndex()
+	_, err := w.WriteHeader("a.txt", 10, nil)
+	is.NoErr(err)
+	is.True(errors.Is(w.Close(), io.ErrShortWrite))
+}
+
+func TestArchiveFS_Index(t *testing.T) {
+	var (
+		is   = is.New(t)
+		fsys = dedupTestFS()
+		buf  = bytes.NewBuffer(nil)
+	)
+
+	err := ArchiveFS(&nopCloser{buf}, fsys, "tree", &ArchiveOptions{
+		Metadata:      true,
+		Dedup:         true,
+		Index:         true,
+		IndexDataKeys: []string{DataKeyDigest},
+	})
+	is.NoErr(err)
+
+	archive := buf.Bytes()
+	ix, err := OpenIndex(bytes.NewReader(archive), int64(len(archive)))
+	is.NoErr(err)
+	is.Equal(ix.Len(), len(fsys))
+
+	item, err := ix.Lookup("tree/c/lib.so")
+	is.NoErr(err)
+	copies, err := ix.LookupData(DataKeyDigest, item.Data[DataKeyDigest][0])
+	is.NoErr(err)
+	is.Equal(len(copies), 3)
+}
@@ -0,0 +1,105 @@
+package pitch
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"time"
+)
+
+// modeBits maps the special fs.FileMode bits onto their traditional unix values.
+var modeBits = []struct {
+	mode fs.FileMode
+	bits uint32
+}{
+	{fs.ModeSetuid, 0o4000},
+	{fs.ModeSetgid, 0o2000},
+	{fs.ModeSticky, 0o1000},
+}
+
+// FormatMode formats the permission and special bits of m as a unix style octal string, e.g. "0644".
+func FormatMode(m fs.FileMode) string {
+	mode := uint32(m.Perm())
+	for _, b := range modeBits {
+		if m&b.mode != 0 {
+			mode |= b.bits
+		}
+	}
+	return fmt.Sprintf("%04o", mode)
+}
+
+// ParseMode parses a mode formatted by FormatMode.
+func ParseMode(s string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode %q: %w", s, err)
+	}
+
+	m := fs.FileMode(mode) & fs.ModePerm
+	for _, b := range modeBits {
+		if uint32(mode)&b.bits != 0 {
+			m |= b.mode
+		}
+	}
+	return m, nil
+}
+
+// FormatModTime formats t the way modification times are stored in header data.
+func FormatModTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// ParseModTime parses a modification time formatted by FormatModTime.
+func ParseModTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// SourceDateEpoch returns the time held by the SOURCE_DATE_EPOCH environment variable,
+// or the zero time if it is not set.
+// It is meant to be used as ArchiveOptions.ModTimeClamp.
+func SourceDateEpoch() (time.Time, error) {
+	v := os.Getenv("SOURCE_DATE_EPOCH")
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH: %w", err)
+	}
+
+	return time.Unix(sec, 0).UTC(), nil
+}
+
+// inode identifies a file independently of the names linking to it.
+type inode struct {
+	dev, ino uint64
+}
+
+// fileData returns the header data recording the metadata in info, as configured by opts.
+func fileData(info fs.FileInfo, opts *ArchiveOptions) map[string][]string {
+	if !opts.Metadata {
+		return nil
+	}
+
+	modTime := info.ModTime()
+	if clamp := opts.ModTimeClamp; !clamp.IsZero() && modTime.After(clamp) {
+		modTime = clamp
+	}
+
+	data := map[string][]string{
+		DataKeyMode:    {FormatMode(info.Mode())},
+		DataKeyModTime: {FormatModTime(modTime)},
+	}
+
+	if uid, gid, ok := fileOwner(info); ok {
+		if opts.ZeroOwner {
+			uid, gid = 0, 0
+		}
+		data[DataKeyUID] = []string{strconv.Itoa(uid)}
+		data[DataKeyGID] = []string{strconv.Itoa(gid)}
+	}
+
+	return data
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package pitch
+
+import "io/fs"
+
+// fileOwner returns the numeric owner of the file described by info.
+// Ownership is not available on this platform.
+func fileOwner(info fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// fileID reports that hard links cannot be detected.
+func fileID(info fs.FileInfo) (id inode, ok bool) {
+	return inode{}, false
+}
@@ -0,0 +1,109 @@
+package pitch
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestFormatMode(t *testing.T) {
+	var (
+		is = is.New(t)
+
+		tests = []struct {
+			mode     fs.FileMode
+			expected string
+		}{
+			{0o644, "0644"},
+			{0o755, "0755"},
+			{0o755 | fs.ModeSetuid, "4755"},
+			{0o775 | fs.ModeSetgid | fs.ModeSticky, "3775"},
+			{0o600 | fs.ModeDir, "0600"},
+		}
+	)
+
+	for _, test := range tests {
+		s := FormatMode(test.mode)
+		is.Equal(s, test.expected)
+
+		m, err := ParseMode(s)
+		is.NoErr(err)
+		is.Equal(m, test.mode&^fs.ModeType)
+	}
+
+	_, err := ParseMode("rw-r--r--")
+	is.True(err != nil)
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	var is = is.New(t)
+
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	epoch, err := SourceDateEpoch()
+	is.NoErr(err)
+	is.True(epoch.IsZero())
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	epoch, err = SourceDateEpoch()
+	is.NoErr(err)
+	is.Equal(epoch, time.Unix(1700000000, 0).UTC())
+
+	t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	_, err = SourceDateEpoch()
+	is.True(err != nil)
+}
+
+func TestArchiveDir_Reproducible(t *testing.T) {
+	var (
+		is    = is.New(t)
+		files = map[string][]byte{
+			"a.txt":         []byte("AAA"),
+			"foo/b.txt":     []byte("BBB"),
+			"foo/bar/c.txt": []byte("CCC"),
+		}
+		opts = ArchiveOptions{
+			Metadata:     true,
+			ModTimeClamp: time.Unix(1700000000, 0),
+			ZeroOwner:    true,
+		}
+		archives [2][]byte
+	)
+
+	for i := range archives {
+		var (
+			dir = filepath.Join(t.TempDir(), "tree")
+			buf = bytes.NewBuffer(nil)
+		)
+
+		err := createTestDir(dir, files, nil)
+		is.NoErr(err)
+		for name := range files {
+			err := os.Chmod(filepath.Join(dir, name), 0o640)
+			is.NoErr(err)
+			// every run sees different modification times, all later than the clamp
+			err = os.Chtimes(filepath.Join(dir, name), time.Now(), time.Now().Add(time.Duration(i)*time.Hour))
+			is.NoErr(err)
+		}
+
+		err = ArchiveDirWithOptions(&nopCloser{buf}, dir, &opts)
+		is.NoErr(err)
+		archives[i] = buf.Bytes()
+	}
+
+	is.Equal(archives[0], archives[1])
+
+	_, headers, err := readArchive(NewReader(bytes.NewReader(archives[0])))
+	is.NoErr(err)
+
+	hdr := headers["tree/foo/b.txt"]
+	is.Equal(hdr.Data[DataKeyMode], []string{"0640"})
+	is.Equal(hdr.Data[DataKeyModTime], []string{FormatModTime(opts.ModTimeClamp)})
+	if uid, ok := hdr.Data[DataKeyUID]; ok {
+		is.Equal(uid, []string{"0"})
+	}
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package pitch
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileOwner returns the numeric owner of the file described by info.
+func fileOwner(info fs.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
+
+// fileID returns the device and inode of the file described by info,
+// if other names may link to it.
+func fileID(info fs.FileInfo) (id inode, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return inode{}, false
+	}
+	return inode{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,59 @@
+package pitch
+
+import "io"
+
+// Observer is notified as entries are written or extracted.
+//
+// Every entry gets an EntryStart, any number of EntryProgress and a final EntryDone call.
+// When entries are extracted in parallel the calls for different entries interleave,
+// but calls are never concurrent.
+type Observer interface {
+	// EntryStart is called before the content of an entry is processed.
+	EntryStart(hdr *Header)
+	// EntryProgress is called as content is processed; n is the number of bytes since the previous call.
+	EntryProgress(hdr *Header, n int64)
+	// EntryDone is called once an entry is complete, err is not nil if it failed.
+	EntryDone(hdr *Header, err error)
+}
+
+// observed tracks the entry a writer is working on for its Observer.
+type observed struct {
+	o   Observer
+	hdr *Header
+}
+
+func (ob *observed) start(hdr *Header) {
+	if ob.o == nil {
+		return
+	}
+	ob.hdr = hdr
+	ob.o.EntryStart(hdr)
+}
+
+func (ob *observed) progress(n int64) {
+	if ob.o == nil || ob.hdr == nil || n == 0 {
+		return
+	}
+	ob.o.EntryProgress(ob.hdr, n)
+}
+
+func (ob *observed) done(err error) {
+	if ob.o == nil || ob.hdr == nil {
+		return
+	}
+	hdr := ob.hdr
+	ob.hdr = nil
+	ob.o.EntryDone(hdr, err)
+}
+
+// progressWriter calls progress with the size of every write.
+type progressWriter struct {
+	w        io.Writer
+	progress func(n int64)
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.progress(int64(n))
+	return n, err
+}
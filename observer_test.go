@@ -0,0 +1,118 @@
+package pitch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+// recordingObserver records the events it sees.
+type recordingObserver struct {
+	events []string
+	// open holds the entries that were started but are not done yet.
+	open  map[string]bool
+	bytes map[string]int64
+	errs  map[string]error
+}
+
+func newRecordingObserver() *recordingObserver {
+	return &recordingObserver{
+		open:  make(map[string]bool),
+		bytes: make(map[string]int64),
+		errs:  make(map[string]error),
+	}
+}
+
+func (ro *recordingObserver) EntryStart(hdr *Header) {
+	ro.events = append(ro.events, "start "+hdr.Name)
+	ro.open[hdr.Name] = true
+}
+
+func (ro *recordingObserver) EntryProgress(hdr *Header, n int64) {
+	ro.bytes[hdr.Name] += n
+}
+
+func (ro *recordingObserver) EntryDone(hdr *Header, err error) {
+	ro.events = append(ro.events, "done "+hdr.Name)
+	delete(ro.open, hdr.Name)
+	ro.errs[hdr.Name] = err
+}
+
+func TestWriter_Observer(t *testing.T) {
+	var (
+		is = is.New(t)
+		o  = newRecordingObserver()
+		w  = NewWriter(bytes.NewBuffer(nil))
+	)
+	w.SetObserver(o)
+
+	_, err := w.WriteHeader("a.txt", 6, nil)
+	is.NoErr(err)
+	_, err = w.Write([]byte("AAA"))
+	is.NoErr(err)
+	_, err = w.Write([]byte("AAA"))
+	is.NoErr(err)
+
+	_, err = w.WriteHeader("empty.txt", 0, nil)
+	is.NoErr(err)
+
+	_, err = w.WriteHeader("short.txt", 3, nil)
+	is.NoErr(err)
+	_, err = w.Write([]byte("S"))
+	is.NoErr(err)
+	is.NoErr(w.Close())
+
+	is.Equal(o.events, []string{
+		"start a.txt", "done a.txt",
+		"start empty.txt", "done empty.txt",
+		"start short.txt", "done short.txt",
+	})
+	is.Equal(o.bytes["a.txt"], int64(6))
+	is.Equal(o.bytes["short.txt"], int64(1))
+	is.NoErr(o.errs["a.txt"])
+	is.True(errors.Is(o.errs["short.txt"], io.ErrShortWrite))
+}
+
+func TestObserver_ArchiveAndExtract(t *testing.T) {
+	var (
+		is   = is.New(t)
+		fsys = extractTestFS()
+		buf  = bytes.NewBuffer(nil)
+
+		archived  = newRecordingObserver()
+		extracted = newRecordingObserver()
+	)
+
+	err := ArchiveFS(&nopCloser{buf}, fsys, "tree", &ArchiveOptions{
+		Symlinks:    SymlinkStore,
+		Concurrency: 4,
+		Observer:    archived,
+	})
+	is.NoErr(err)
+
+	toc, err := BuildTableOfContents(buf.Bytes())
+	is.NoErr(err)
+
+	err = ExtractAt(context.Background(), t.TempDir(), bytes.NewReader(buf.Bytes()), toc, &ExtractOptions{
+		Concurrency: 4,
+		Observer:    extracted,
+	})
+	is.NoErr(err)
+
+	for _, o := range []*recordingObserver{archived, extracted} {
+		is.Equal(len(o.events), 2*len(fsys))
+		is.Equal(len(o.open), 0)
+		for name, file := range fsys {
+			var expected = int64(len(file.Data))
+			if !file.Mode.IsRegular() {
+				expected = 0
+			}
+			is.Equal(o.bytes[name], expected)
+			is.NoErr(o.errs[name])
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package pitch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DefaultMemoryBudget is the MemoryBudget used when ArchiveOptions does not set one.
+const DefaultMemoryBudget = 64 << 20
+
+var errArchiveStopped = errors.New("pitch: archiving stopped")
+
+// pendingEntry is an entry waiting to be written, along with its prefetched content.
+type pendingEntry struct {
+	*archiveEntry
+	// reserved is the amount of the memory budget held by content.
+	reserved int64
+	content  []byte
+	err      error
+	done     chan struct{}
+}
+
+// archiveParallel runs walk while a pool of workers reads the content of the emitted entries
+// ahead of the writer. Entries are written in the order they were emitted.
+func (a *archiver) archiveParallel(walk func() error) error {
+	var (
+		limit  = a.opts.MemoryBudget
+		budget *memoryBudget
+
+		jobs  = make(chan *pendingEntry)
+		queue = make(chan *pendingEntry, 4*a.opts.Concurrency)
+		stop  = make(chan struct{})
+
+		workers   sync.WaitGroup
+		writeErr  error
+		writeDone = make(chan struct{})
+	)
+	if limit <= 0 {
+		limit = DefaultMemoryBudget
+	}
+	budget = newMemoryBudget(limit)
+
+	for i := 0; i < a.opts.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for pe := range jobs {
+				a.prefetch(pe)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(writeDone)
+		for pe := range queue {
+			<-pe.done
+			// after a failure the queue is still drained so the walk never blocks on it
+			if writeErr == nil {
+				if writeErr = pe.err; writeErr == nil {
+					writeErr = a.writeEntry(pe.archiveEntry, pe.content)
+				}
+				if writeErr != nil {
+					close(stop)
+					budget.close()
+				}
+			}
+			pe.content = nil
+			pe.closeFile()
+			budget.release(pe.reserved)
+		}
+	}()
+
+	a.emit = func(e *archiveEntry) error {
+		pe := pendingEntry{
+			archiveEntry: e,
+			done:         make(chan struct{}),
+		}
+
+		// budget is taken in walk order, so the entry the writer is waiting on
+		// has always been handed to a worker
+		if e.src != "" && e.size <= limit {
+			if !budget.acquire(e.size) {
+				return errArchiveStopped
+			}
+			pe.reserved = e.size
+			select {
+			case jobs <- &pe:
+			case <-stop:
+				budget.release(pe.reserved)
+				return errArchiveStopped
+			case <-a.ctx.Done():
+				budget.release(pe.reserved)
+				return fmt.Errorf("error archiving %s: %w", e.name, a.ctx.Err())
+			}
+		} else {
+			close(pe.done)
+		}
+
+		// once the worker is done with an entry that is not queued, the walk may release it
+		select {
+		case queue <- &pe:
+			return nil
+		case <-stop:
+			<-pe.done
+			return errArchiveStopped
+		case <-a.ctx.Done():
+			<-pe.done
+			return fmt.Errorf("error archiving %s: %w", e.name, a.ctx.Err())
+		}
+	}
+
+	walkErr := walk()
+	close(jobs)
+	close(queue)
+	<-writeDone
+	workers.Wait()
+
+	if writeErr != nil {
+		return writeErr
+	}
+	return walkErr
+}
+
+// prefetch reads the content of pe into memory.
+func (a *archiver) prefetch(pe *pendingEntry) {
+	defer close(pe.done)
+
+	file, err := a.openContent(pe.archiveEntry)
+	if err != nil {
+		pe.err = err
+		return
+	}
+	defer file.Close()
+
+	pe.content = make([]byte, pe.size)
+	if _, err := io.ReadFull(&contextReader{ctx: a.ctx, r: file}, pe.content); err != nil {
+		pe.err = fmt.Errorf("error reading file [%s]: %w", pe.src, err)
+	}
+}
+
+// memoryBudget is a counting semaphore over a number of bytes.
+type memoryBudget struct {
+	mu        sync.Mutex
+	cond      sync.Cond
+	available int64
+	closed    bool
+}
+
+func newMemoryBudget(n int64) *memoryBudget {
+	b := memoryBudget{
+		available: n,
+	}
+	b.cond.L = &b.mu
+	return &b
+}
+
+// acquire blocks until n bytes are available and takes them.
+// It returns false if the budget was closed first.
+func (b *memoryBudget) acquire(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.available < n && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return false
+	}
+
+	b.available -= n
+	return true
+}
+
+func (b *memoryBudget) release(n int64) {
+	if n == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	b.available += n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// close wakes every blocked acquire.
+func (b *memoryBudget) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
@@ -0,0 +1,102 @@
+package pitch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+func TestArchiveFS_Parallel(t *testing.T) {
+	var (
+		is   = is.New(t)
+		fsys = make(fstest.MapFS)
+
+		tests = []struct {
+			name string
+			opts ArchiveOptions
+		}{
+			{
+				name: "default_budget",
+				opts: ArchiveOptions{Concurrency: 4},
+			},
+			{
+				name: "small_budget",
+				opts: ArchiveOptions{Concurrency: 8, MemoryBudget: 1024},
+			},
+			{
+				name: "tiny_budget",
+				opts: ArchiveOptions{Concurrency: 2, MemoryBudget: 1},
+			},
+		}
+	)
+
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("tree/dir%d/file%03d.txt", i%7, i)
+		fsys[name] = &fstest.MapFile{
+			Data: []byte(strings.Repeat(name, i)),
+		}
+	}
+
+	expected := bytes.NewBuffer(nil)
+	err := ArchiveFS(&nopCloser{expected}, fsys, "tree", nil)
+	is.NoErr(err)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				is  = is.New(t)
+				buf = bytes.NewBuffer(nil)
+			)
+
+			err := ArchiveFS(&nopCloser{buf}, fsys, "tree", &test.opts)
+			is.NoErr(err)
+			is.Equal(buf.Bytes(), expected.Bytes())
+		})
+	}
+}
+
+func TestArchiveFS_ParallelError(t *testing.T) {
+	var (
+		is   = is.New(t)
+		fsys = make(fstest.MapFS)
+		buf  = bytes.NewBuffer(nil)
+	)
+
+	for i := 0; i < 100; i++ {
+		fsys[fmt.Sprintf("tree/file%03d.txt", i)] = &fstest.MapFile{
+			Data: []byte("contents"),
+		}
+	}
+
+	err := ArchiveFS(&nopCloser{buf}, &failingFS{fsys, "tree/file042.txt"}, "tree", &ArchiveOptions{
+		Concurrency:  4,
+		MemoryBudget: 16,
+	})
+	is.True(errors.Is(err, errOpenFailed))
+
+	// everything before the failing file made it into the archive
+	contents, _, err := readArchive(NewReader(buf))
+	is.NoErr(err)
+	is.Equal(len(contents), 42)
+}
+
+var errOpenFailed = errors.New("open failed")
+
+// failingFS fails to open a single file.
+type failingFS struct {
+	fs.FS
+	name string
+}
+
+func (f *failingFS) Open(name string) (fs.File, error) {
+	if name == f.name {
+		return nil, errOpenFailed
+	}
+	return f.FS.Open(name)
+}
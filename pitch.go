@@ -2,119 +2,262 @@ package pitch
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"iter"
 	"os"
+	"path"
 	"path/filepath"
-	"strings"
 )
 
 func BuildTableOfContents(v any) (TableOfContents, error) {
+	return BuildTableOfContentsContext(context.Background(), v)
+}
+
+// BuildTableOfContentsContext is like BuildTableOfContents but gives up once ctx is done.
+func BuildTableOfContentsContext(ctx context.Context, v any) (TableOfContents, error) {
 	switch x := v.(type) {
 	case []byte:
 		var buf = bytes.NewReader(x)
-		return buildTableOfContentsFromReader(NewReader(buf))
+		return buildTableOfContentsFromReader(ctx, NewReader(buf))
 	case Reader:
-		return buildTableOfContentsFromReader(x)
+		return buildTableOfContentsFromReader(ctx, x)
 	case io.Reader:
-		return buildTableOfContentsFromReader(NewReader(x))
+		return buildTableOfContentsFromReader(ctx, NewReader(x))
 	}
 
 	return nil, errors.New("expected []byte, Reader or io.Reader")
 }
 
-func buildTableOfContentsFromReader(r Reader) (TableOfContents, error) {
-	var (
-		toc    = make(TableOfContents)
-		offset int64
-	)
+// contentRanger is implemented by readers that know where the content of the current entry lies.
+type contentRanger interface {
+	contentRange() (start, end int64)
+}
 
-	for {
-		hdr, err := r.Next()
+// buildTableOfContentsFromReader indexes every entry read from r, see ScanTableOfContents.
+func buildTableOfContentsFromReader(ctx context.Context, r Reader) (TableOfContents, error) {
+	toc := make(TableOfContents)
+	for item, err := range ScanTableOfContentsContext(ctx, r) {
 		if err != nil {
+			return nil, err
+		}
+		toc[item.Name] = item
+	}
+
+	if len(toc) == 0 {
+		return nil, io.EOF
+	}
+	return toc, nil
+}
+
+// ScanTableOfContents returns an iterator over the items of the entries read from r, in archive order.
+// Unlike a TableOfContents, it holds on to nothing, so it suits archives too large to index in memory.
+// Iteration stops at the end of the archive or after yielding the first error.
+//
+// Reference entries are yielded as the regular files they stand for, and sparse files with their logical size;
+// Start and End always delimit the bytes stored in the archive.
+func ScanTableOfContents(r Reader) iter.Seq2[*HeaderItem, error] {
+	return ScanTableOfContentsContext(context.Background(), r)
+}
+
+// ScanTableOfContentsContext is like ScanTableOfContents but gives up once ctx is done.
+func ScanTableOfContentsContext(ctx context.Context, r Reader) iter.Seq2[*HeaderItem, error] {
+	return func(yield func(*HeaderItem, error) bool) {
+		var (
+			offset    int64
+			ranger, _ = r.(contentRanger)
+		)
+
+		for {
+			hdr, err := NextContext(ctx, r)
 			if errors.Is(err, io.EOF) {
-				if len(toc) == 0 {
-					return nil, io.EOF
+				return
+			}
+			if err != nil {
+				yield(nil, fmt.Errorf("error reading header: %w", err))
+				return
+			}
+
+			var item = HeaderItem{
+				Name: hdr.Name,
+				Size: hdr.Size,
+				Data: hdr.Data,
+			}
+
+			switch {
+			case ranger != nil:
+				item.Start, item.End = ranger.contentRange()
+			default:
+				// the bytes of entries the reader skipped, such as archive data, come first
+				if s, ok := r.(skipper); ok {
+					offset += s.skipped()
+				}
+				// sparse files are handed out with their logical size, but only their data is stored
+				stored, err := storedSize(hdr)
+				if err != nil {
+					yield(nil, fmt.Errorf("error reading %s: %w", hdr.Name, err))
+					return
 				}
-				return toc, nil
+				headerSize := int64(EncodedHeaderSize(hdr.Name, stored, hdr.Data))
+				item.Start = offset + headerSize
+				item.End = item.Start + int64(stored)
+				offset = item.End
 			}
-			return nil, fmt.Errorf("error reading header: %w", err)
-		}
 
-		headerSize := int64(EncodedHeaderSize(hdr.Name, hdr.Size, hdr.Data))
-		filesize := headerSize + int64(hdr.Size)
+			// readers without random access hand out references as they are,
+			// as do readers other than the ones returned by NewReader with sparse files
+			if err := resolveItem(&item); err != nil {
+				yield(nil, err)
+				return
+			}
 
-		toc[hdr.Name] = &HeaderItem{
-			Name:  hdr.Name,
-			Size:  hdr.Size,
-			Data:  hdr.Data,
-			Start: offset + headerSize,
-			End:   offset + filesize,
+			if !yield(&item, nil) {
+				return
+			}
 		}
-		offset += filesize
 	}
 }
 
-func WalkDirFunc(w *Writer, dir string) fs.WalkDirFunc {
-	dir = filepath.Clean(dir)
-	dirParent := filepath.Dir(dir)
-	sep := string(filepath.Separator)
-	return func(path string, entry fs.DirEntry, err error) error {
+// resolveItem turns item, which may describe an entry as it is stored, into the file the entry stands for:
+// references are resolved and sparse files get their logical size.
+func resolveItem(item *HeaderItem) error {
+	if EntryType(item.Data) == EntryTypeRef {
+		resolved, refOffset, err := resolveReference(item.Header())
 		if err != nil {
 			return err
 		}
+		item.Size = resolved.Size
+		item.Data = resolved.Data
+		item.Start = refOffset
+		item.End = refOffset + int64(resolved.Size)
+	}
+
+	_, size, sparse, err := expandSparse(item.Data, uint64(item.End-item.Start))
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", item.Name, err)
+	}
+	if sparse {
+		item.Size = uint64(size)
+	}
+	return nil
+}
+
+// WalkDirFunc returns an fs.WalkDirFunc that writes every file it visits into w.
+// It is meant to be used with filepath.WalkDir(dir, ...).
+func WalkDirFunc(w *Writer, dir string) fs.WalkDirFunc {
+	return WalkDirFuncWithOptions(w, dir, nil)
+}
 
-		if entry.IsDir() {
-			return nil
+// WalkDirFuncWithOptions is like WalkDirFunc but archives files as configured by opts.
+// Files are always written one at a time, opts.Concurrency is ignored.
+func WalkDirFuncWithOptions(w *Writer, dir string, opts *ArchiveOptions) fs.WalkDirFunc {
+	a, err := newDirArchiver(w, dir, opts)
+	if err != nil {
+		return func(string, fs.DirEntry, error) error {
+			return err
 		}
+	}
 
-		info, err := entry.Info()
+	return func(p string, entry fs.DirEntry, err error) error {
 		if err != nil {
-			return fmt.Errorf("error getting file info: %w", err)
+			return err
 		}
 
-		// follow symlinks
-		headerName := strings.TrimPrefix(path, dirParent+sep)
-		size := info.Size()
-		if entry.Type()&fs.ModeSymlink != 0 {
-			path, err = os.Readlink(path)
-			if err != nil {
-				return fmt.Errorf("error reading symlink: %w", err)
-			}
-			stat, err := os.Stat(path)
-			if err != nil {
-				return fmt.Errorf("error getting file info: %w", err)
-			}
-			size = stat.Size()
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return fmt.Errorf("error getting relative path: %w", err)
 		}
 
-		if _, err := w.WriteHeader(headerName, size, nil); err != nil {
-			return fmt.Errorf("error writing header (%s, %d): %w", headerName, info.Size(), err)
-		}
+		return a.walkDirFunc(path.Join(a.root, filepath.ToSlash(rel)), entry, nil)
+	}
+}
+
+// ArchiveDir writes every file under dir into dst as a pitch archive.
+// Only the parent of dir is walked: symlinks that lead out of it are followed through the OS
+// when they point at regular files, and cannot be followed otherwise, see SymlinkPolicy.
+func ArchiveDir(dst io.WriteCloser, dir string) error {
+	return ArchiveDirWithOptions(dst, dir, nil)
+}
+
+// ArchiveDirWithOptions is like ArchiveDir but archives files as configured by opts.
+func ArchiveDirWithOptions(dst io.WriteCloser, dir string, opts *ArchiveOptions) error {
+	return ArchiveDirContext(context.Background(), dst, dir, opts)
+}
 
-		file, err := os.Open(path)
+// ArchiveDirContext is like ArchiveDirWithOptions but gives up once ctx is done.
+func ArchiveDirContext(ctx context.Context, dst io.WriteCloser, dir string, opts *ArchiveOptions) error {
+	return ArchiveDirsContext(ctx, dst, []string{dir}, opts)
+}
+
+// ArchiveDirs writes every file under each of dirs into dst as a single pitch archive.
+// Every directory is stored under its base name, see ArchiveDir. Hard links and duplicate
+// contents are found across all of them, see ArchiveOptions.Hardlinks and ArchiveOptions.Dedup.
+func ArchiveDirs(dst io.WriteCloser, dirs []string, opts *ArchiveOptions) error {
+	return ArchiveDirsContext(context.Background(), dst, dirs, opts)
+}
+
+// ArchiveDirsContext is like ArchiveDirs but gives up once ctx is done.
+func ArchiveDirsContext(ctx context.Context, dst io.WriteCloser, dirs []string, opts *ArchiveOptions) error {
+	return archiveTrees(ctx, dst, dirs, func(w *Writer, dir string) (*archiver, error) {
+		return newDirArchiver(w, dir, opts)
+	})
+}
+
+// archiveTrees writes every tree in roots into dst as a single pitch archive,
+// using the archiver newArchiver returns for each of them.
+func archiveTrees(ctx context.Context, dst io.WriteCloser, roots []string, newArchiver func(w *Writer, root string) (*archiver, error)) error {
+	var (
+		pw    = NewWriter(dst)
+		first *archiver
+	)
+
+	for _, root := range roots {
+		a, err := newArchiver(pw, root)
 		if err != nil {
-			return fmt.Errorf("error opening file: %w", err)
+			return closeWriter(pw, err)
 		}
+		a.ctx = ctx
 
-		if _, err := io.Copy(w, file); err != nil {
-			file.Close()
-			return fmt.Errorf("error copying file [%s]: %w", path, err)
+		if first == nil {
+			first = a
+			pw.SetObserver(a.opts.Observer)
+			if a.opts.Index {
+				pw.EnableIndex(a.opts.IndexDataKeys...)
+			}
+		} else {
+			// later trees link and refer to the files written before them
+			a.inodes, a.seen = first.inodes, first.seen
 		}
 
-		if err := file.Close(); err != nil {
-			return fmt.Errorf("error closing file: %w", err)
+		err = a.archive(func() error {
+			return fs.WalkDir(a.fsys, a.root, a.walkDirFunc)
+		})
+		if err != nil {
+			return closeWriter(pw, err)
 		}
-
-		return nil
 	}
+	return closeWriter(pw, nil)
 }
 
-func ArchiveDir(dst io.WriteCloser, dir string) error {
-	var pw = NewWriter(dst)
-	defer pw.Close()
+// newDirArchiver returns an archiver for the OS directory dir.
+// The archiver sees the parent of dir, whose path lets absolute symlink targets within it be resolved.
+func newDirArchiver(w *Writer, dir string, opts *ArchiveOptions) (*archiver, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error getting absolute path: %w", err)
+	}
+
+	parent, root := filepath.Dir(abs), filepath.Base(abs)
+	if parent == abs {
+		// dir is the root of a volume
+		root = "."
+	}
+
+	a := newArchiver(w, os.DirFS(parent), root, opts)
+	a.osDir = parent
 
-	return filepath.WalkDir(dir, WalkDirFunc(pw, dir))
+	return a, nil
 }
@@ -306,8 +306,11 @@ func TestArchiveDir(t *testing.T) {
 				tempDirName = filepath.Base(tempDir)
 			)
 
-			err := createTestDir(tempDir, files, map[string]string{
-				"go.mod": "./go.mod",
+			goMod, err := filepath.Abs("go.mod")
+			is.NoErr(err)
+
+			err = createTestDir(tempDir, files, map[string]string{
+				"go.mod": goMod,
 			})
 			is.NoErr(err)
 
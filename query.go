@@ -0,0 +1,400 @@
+package pitch
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidQuery is returned by ParseQuery for malformed queries.
+var ErrInvalidQuery = errors.New("pitch: invalid query")
+
+// Query is a predicate over entry headers, see ParseQuery.
+// Queries only look at headers, never at content.
+type Query struct {
+	src   string
+	match func(*Header) bool
+}
+
+// ParseQuery parses a query. A query is made of comparisons, combined with
+// "and", "or", "not" and parentheses; comparisons next to each other must all hold:
+//
+//	name~*.go and size>=1k
+//	type=dir or (data.Owner=web not data.Content-Type~text/*)
+//
+// Comparisons are FIELD OP VALUE, where FIELD is one of:
+//
+//   - name: the entry name. ~ matches a path.Match pattern against the whole name,
+//     or against the last element if the pattern has no slash.
+//   - size: the content size, with an optional k, m, g or t suffix for powers of 1024.
+//   - type: the entry type, see DataKeyType; regular files are of type "file".
+//   - data.KEY: the values of header data key KEY. A comparison holds if it holds for
+//     any value; != holds if no value is equal. Values that are both integers are
+//     compared as numbers. data.KEY on its own holds if the key is present.
+//
+// OP is one of =, !=, <, <=, >, >= and ~. Values with spaces, parentheses or operator
+// characters are written as double quoted Go strings, as are such keys: data."Build Id".
+func ParseQuery(s string) (*Query, error) {
+	toks, err := lexQuery(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := queryParser{toks: toks}
+	match, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidQuery, p.toks[p.pos].text)
+	}
+
+	return &Query{
+		src:   s,
+		match: match,
+	}, nil
+}
+
+// String returns the source of q.
+func (q *Query) String() string {
+	return q.src
+}
+
+// Match reports whether hdr matches q.
+func (q *Query) Match(hdr *Header) bool {
+	return q.match(hdr)
+}
+
+// Find returns the items of toc that match q, sorted by name.
+func (toc TableOfContents) Find(q *Query) ListOfContentsByName {
+	var found ListOfContentsByName
+	for _, item := range toc.ByName() {
+		if q.Match(item.Header()) {
+			found = append(found, item)
+		}
+	}
+	return found
+}
+
+type queryTokenKind int
+
+const (
+	queryWord queryTokenKind = iota
+	queryString
+	queryOp
+	queryLParen
+	queryRParen
+)
+
+type queryToken struct {
+	kind queryTokenKind
+	text string
+}
+
+const queryOpChars = "=!<>~"
+
+func lexQuery(s string) ([]queryToken, error) {
+	var toks []queryToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '(':
+			toks = append(toks, queryToken{kind: queryLParen, text: "("})
+			i++
+		case c == ')':
+			toks = append(toks, queryToken{kind: queryRParen, text: ")"})
+			i++
+		case c == '"':
+			quoted, err := strconv.QuotedPrefix(s[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%w: unterminated string at offset %d", ErrInvalidQuery, i)
+			}
+			text, _ := strconv.Unquote(quoted)
+			toks = append(toks, queryToken{kind: queryString, text: text})
+			i += len(quoted)
+		case strings.IndexByte(queryOpChars, c) != -1:
+			j := i
+			for j < len(s) && strings.IndexByte(queryOpChars, s[j]) != -1 {
+				j++
+			}
+			op := s[i:j]
+			switch op {
+			case "=", "!=", "<", "<=", ">", ">=", "~":
+			default:
+				return nil, fmt.Errorf("%w: unknown operator %q", ErrInvalidQuery, op)
+			}
+			toks = append(toks, queryToken{kind: queryOp, text: op})
+			i = j
+		default:
+			j := i
+			for j < len(s) && !unicode.IsSpace(rune(s[j])) && strings.IndexByte(`()"`+queryOpChars, s[j]) == -1 {
+				j++
+			}
+			toks = append(toks, queryToken{kind: queryWord, text: s[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type queryParser struct {
+	toks []queryToken
+	pos  int
+}
+
+func (p *queryParser) peek() (queryToken, bool) {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos], true
+	}
+	return queryToken{}, false
+}
+
+func (p *queryParser) isKeyword(word string) bool {
+	tok, ok := p.peek()
+	return ok && tok.kind == queryWord && tok.text == word
+}
+
+func (p *queryParser) parseOr() (func(*Header) bool, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(hdr *Header) bool { return l(hdr) || right(hdr) }
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (func(*Header) bool, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		switch {
+		case !ok || tok.kind == queryRParen || p.isKeyword("or"):
+			return left, nil
+		case p.isKeyword("and"):
+			p.pos++
+		}
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(hdr *Header) bool { return l(hdr) && right(hdr) }
+	}
+}
+
+func (p *queryParser) parseUnary() (func(*Header) bool, error) {
+	tok, ok := p.peek()
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("%w: unexpected end of query", ErrInvalidQuery)
+	case p.isKeyword("not"):
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(hdr *Header) bool { return !operand(hdr) }, nil
+	case tok.kind == queryLParen:
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, ok := p.peek(); !ok || tok.kind != queryRParen {
+			return nil, fmt.Errorf("%w: missing )", ErrInvalidQuery)
+		}
+		p.pos++
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() (func(*Header) bool, error) {
+	tok, _ := p.peek()
+	if tok.kind != queryWord {
+		return nil, fmt.Errorf("%w: expected a field, got %q", ErrInvalidQuery, tok.text)
+	}
+	p.pos++
+
+	field := tok.text
+	if field == "data." {
+		if tok, ok := p.peek(); ok && tok.kind == queryString {
+			field += tok.text
+			p.pos++
+		}
+	}
+
+	op, ok := p.peek()
+	if !ok || op.kind != queryOp {
+		key, isData := strings.CutPrefix(field, "data.")
+		if !isData || key == "" {
+			return nil, fmt.Errorf("%w: %s needs an operator", ErrInvalidQuery, field)
+		}
+		return func(hdr *Header) bool {
+			_, ok := hdr.Data[key]
+			return ok
+		}, nil
+	}
+	p.pos++
+
+	value, ok := p.peek()
+	if !ok || (value.kind != queryWord && value.kind != queryString) {
+		return nil, fmt.Errorf("%w: %s%s needs a value", ErrInvalidQuery, field, op.text)
+	}
+	p.pos++
+
+	match, err := comparison(field, op.text, value.text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s%s%s: %w", ErrInvalidQuery, field, op.text, value.text, err)
+	}
+	return match, nil
+}
+
+func comparison(field, op, value string) (func(*Header) bool, error) {
+	switch field {
+	case "name":
+		return nameComparison(op, value)
+	case "size":
+		if op == "~" {
+			return nil, errors.New("size does not support ~")
+		}
+		size, err := parseQuerySize(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(hdr *Header) bool {
+			return compareOrdered(op, hdr.Size, size)
+		}, nil
+	case "type":
+		if op != "=" && op != "!=" {
+			return nil, errors.New("type only supports = and !=")
+		}
+		if value == "file" {
+			value = ""
+		}
+		return func(hdr *Header) bool {
+			return (EntryType(hdr.Data) == value) == (op == "=")
+		}, nil
+	}
+
+	key, ok := strings.CutPrefix(field, "data.")
+	if !ok || key == "" {
+		return nil, fmt.Errorf("unknown field %s", field)
+	}
+	if op == "~" {
+		if _, err := path.Match(value, ""); err != nil {
+			return nil, err
+		}
+	}
+	return func(hdr *Header) bool {
+		values := hdr.Data[key]
+		if op == "!=" {
+			return !slices.Contains(values, value)
+		}
+		return slices.ContainsFunc(values, func(v string) bool {
+			return compareData(op, v, value)
+		})
+	}, nil
+}
+
+func nameComparison(op, value string) (func(*Header) bool, error) {
+	switch op {
+	case "=", "!=":
+		return func(hdr *Header) bool {
+			return (hdr.Name == value) == (op == "=")
+		}, nil
+	case "~":
+		if _, err := path.Match(value, ""); err != nil {
+			return nil, err
+		}
+		baseOnly := !strings.Contains(value, "/")
+		return func(hdr *Header) bool {
+			name := hdr.Name
+			if baseOnly {
+				name = path.Base(name)
+			}
+			ok, _ := path.Match(value, name)
+			return ok
+		}, nil
+	}
+	return nil, errors.New("name only supports =, != and ~")
+}
+
+func compareData(op, v, value string) bool {
+	if op == "~" {
+		ok, _ := path.Match(value, v)
+		return ok
+	}
+
+	x, errX := strconv.ParseInt(v, 10, 64)
+	y, errY := strconv.ParseInt(value, 10, 64)
+	if errX == nil && errY == nil {
+		return compareOrdered(op, x, y)
+	}
+	return compareOrdered(op, v, value)
+}
+
+func compareOrdered[T int64 | uint64 | string](op string, x, y T) bool {
+	switch op {
+	case "=":
+		return x == y
+	case "!=":
+		return x != y
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	case ">=":
+		return x >= y
+	}
+	return false
+}
+
+// parseQuerySize parses a size with an optional k, m, g or t suffix.
+func parseQuerySize(s string) (uint64, error) {
+	var shift uint
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			shift = 10
+		case 'm', 'M':
+			shift = 20
+		case 'g', 'G':
+			shift = 30
+		case 't', 'T':
+			shift = 40
+		}
+	}
+	if shift != 0 {
+		s = s[:len(s)-1]
+	}
+
+	size, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if size > (1<<64-1)>>shift {
+		return 0, fmt.Errorf("size %s is too large", s)
+	}
+	return size << shift, nil
+}
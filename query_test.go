@@ -0,0 +1,107 @@
+package pitch
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestParseQuery(t *testing.T) {
+	var (
+		html = &Header{Name: "site/index.html", Size: 2048, Data: map[string][]string{
+			"Owner":            {"web", "docs"},
+			"Build":            {"12"},
+			"Build Id":         {"x"},
+			DataKeyContentType: {"text/html"},
+		}}
+		dir  = &Header{Name: "site", Data: map[string][]string{DataKeyType: {EntryTypeDir}}}
+		blob = &Header{Name: "blob.bin", Size: 3 << 20}
+	)
+
+	tests := []struct {
+		query string
+		want  []*Header
+	}{
+		{query: "name=site", want: []*Header{dir}},
+		{query: "name!=site", want: []*Header{html, blob}},
+		{query: "name~*.html", want: []*Header{html}},
+		{query: "name~site/*", want: []*Header{html}},
+		{query: "size>=2k", want: []*Header{html, blob}},
+		{query: "size>2k", want: []*Header{blob}},
+		{query: "size<1m size>0", want: []*Header{html}},
+		{query: "type=dir", want: []*Header{dir}},
+		{query: "type=file", want: []*Header{html, blob}},
+		{query: "data.Owner", want: []*Header{html}},
+		{query: "data.Owner=docs", want: []*Header{html}},
+		{query: "data.Owner!=docs", want: []*Header{dir, blob}},
+		{query: "data.Build>9", want: []*Header{html}},
+		{query: `data."Build Id"="x"`, want: []*Header{html}},
+		{query: "data.Pitch-Content-Type~text/*", want: []*Header{html}},
+		{query: "type=dir or size>1m", want: []*Header{dir, blob}},
+		{query: "not (type=dir or size>1m)", want: []*Header{html}},
+		{query: "name~* and not data.Owner", want: []*Header{dir, blob}},
+		{query: "size>0 and size<4k or type=dir", want: []*Header{html, dir}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			is := is.New(t)
+			q, err := ParseQuery(tt.query)
+			is.NoErr(err)
+			is.Equal(q.String(), tt.query)
+
+			var got []*Header
+			for _, hdr := range []*Header{html, dir, blob} {
+				if q.Match(hdr) {
+					got = append(got, hdr)
+				}
+			}
+			is.Equal(len(got), len(tt.want))
+			for _, hdr := range tt.want {
+				is.True(q.Match(hdr))
+			}
+		})
+	}
+}
+
+func TestParseQuery_Invalid(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"name",
+		"name=",
+		"size=big",
+		"size~1",
+		"type>dir",
+		"owner=web",
+		"(name=a",
+		"name=a)",
+		"name=>a",
+		`name="a`,
+		"name~[",
+		"not",
+	} {
+		t.Run(query, func(t *testing.T) {
+			is := is.New(t)
+			_, err := ParseQuery(query)
+			is.True(errors.Is(err, ErrInvalidQuery))
+		})
+	}
+}
+
+func TestTableOfContents_Find(t *testing.T) {
+	var (
+		is  = is.New(t)
+		toc = TableOfContents{
+			"b.txt": {Name: "b.txt", Size: 1},
+			"a.txt": {Name: "a.txt", Size: 2},
+			"c.bin": {Name: "c.bin", Size: 3},
+		}
+	)
+
+	q, err := ParseQuery("name~*")
+	is.NoErr(err)
+	found := toc.Find(q)
+	is.Equal(len(found), 3)
+	is.Equal(found[0].Name, "a.txt")
+	is.Equal(found[2].Name, "c.bin")
+}
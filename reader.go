@@ -1,9 +1,11 @@
 package pitch
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 )
 
 type Reader interface {
@@ -12,6 +14,49 @@ type Reader interface {
 	Close() error
 }
 
+// ContextReader is a Reader whose NextContext is like Next but gives up once ctx is done,
+// including while skipping over the content of the current entry.
+// The readers returned by NewReader and Cat implement it.
+type ContextReader interface {
+	Reader
+	NextContext(ctx context.Context) (*Header, error)
+}
+
+// NextContext reads the next header from r, giving up once ctx is done.
+// If r is not a ContextReader, ctx is only checked before calling Next.
+func NextContext(ctx context.Context, r Reader) (*Header, error) {
+	if cr, ok := r.(ContextReader); ok {
+		return cr.NextContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("error reading the next header: %w", err)
+	}
+	return r.Next()
+}
+
+// ArchiveDataReader is a Reader that hands out the archive data of its archive, see Writer.SetArchiveData.
+// The readers returned by NewReader and Cat implement it.
+type ArchiveDataReader interface {
+	Reader
+	// ArchiveData returns the archive data of the archive, or nil if it has none.
+	ArchiveData() map[string][]string
+}
+
+// ArchiveData returns the archive data of the archive read by r,
+// or nil if it has none or r is not an ArchiveDataReader.
+func ArchiveData(r Reader) map[string][]string {
+	if adr, ok := r.(ArchiveDataReader); ok {
+		return adr.ArchiveData()
+	}
+	return nil
+}
+
+// skipper is implemented by readers that skip over entries, such as those holding archive data.
+// skipped returns the number of bytes skipped right before the last header returned.
+type skipper interface {
+	skipped() int64
+}
+
 type internalReader interface {
 	Reader
 	reader() io.Reader
@@ -21,8 +66,40 @@ type internalReader interface {
 type reader struct {
 	r             io.Reader
 	contentReader io.LimitedReader
+	// name is the name of the current entry.
+	name string
+	// offset is the offset of the next header within r.
+	offset int64
+	// start and end delimit the content of the current entry within r.
+	start, end int64
+	// ref reads the content of the current entry when it is a resolved reference.
+	ref *io.SectionReader
+	// sparse reads the logical content of the current entry when it is a sparse file.
+	sparse *sparseReader
+	// archiveData holds the data of the archive data entries read so far.
+	archiveData map[string][]string
+	// skip is the number of bytes of the archive data entries read right before the current entry.
+	skip int64
+	// started is set once the first header has been read.
+	started bool
+	// peeked and peekErr hold the result of the read ahead done by ArchiveData
+	// until Next hands it out.
+	peeked  *Header
+	peekErr error
+	// schema checks the data of every entry, if set.
+	schema *Schema
 }
 
+// NewReader returns a Reader over the archive read from r.
+//
+// If r is an io.ReaderAt, reference entries (see EntryTypeRef) are resolved transparently:
+// they are returned as regular files whose content is read from earlier in r.
+// Otherwise they are returned as they are and Extract resolves them.
+//
+// Sparse files (see DataKeySparseMap) are returned with their logical size,
+// and their holes read as zeros.
+//
+// Archive data entries are skipped; their data is returned by ArchiveData instead.
 func NewReader(r io.Reader) Reader {
 	return &reader{
 		r: r,
@@ -33,25 +110,133 @@ func NewReader(r io.Reader) Reader {
 }
 
 func (rdr *reader) Next() (*Header, error) {
-	if err := rdr.discardContent(); err != nil {
-		return nil, fmt.Errorf("error discarding content: %w", err)
+	return rdr.NextContext(context.Background())
+}
+
+func (rdr *reader) NextContext(ctx context.Context) (*Header, error) {
+	if rdr.peeked != nil || rdr.peekErr != nil {
+		hdr, err := rdr.peeked, rdr.peekErr
+		rdr.peeked, rdr.peekErr = nil, nil
+		return hdr, err
 	}
+	rdr.started = true
+	rdr.skip = 0
 
-	hdr, err := DecodeHeader(rdr.r)
+	start := rdr.offset
+	for {
+		hdr, err := rdr.next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !isArchiveData(hdr.Name, hdr.Data) {
+			if rdr.schema != nil {
+				if err := rdr.schema.Validate(hdr.Data); err != nil {
+					return nil, fmt.Errorf("error reading %s: %w", hdr.Name, err)
+				}
+			}
+			return hdr, nil
+		}
+
+		if rdr.archiveData == nil {
+			rdr.archiveData = make(map[string][]string)
+		}
+		maps.Copy(rdr.archiveData, archiveDataOf(hdr.Data))
+		rdr.skip = rdr.offset - start
+	}
+}
+
+// ArchiveData returns the data of the archive data entries read so far.
+// If no header has been read yet, it reads ahead to the first entry, which Next returns next.
+func (rdr *reader) ArchiveData() map[string][]string {
+	if !rdr.started {
+		rdr.peeked, rdr.peekErr = rdr.NextContext(context.Background())
+	}
+	return rdr.archiveData
+}
+
+// next reads the next header, whatever entry it belongs to.
+func (rdr *reader) next(ctx context.Context) (*Header, error) {
+	if err := rdr.discardContentContext(ctx); err != nil {
+		return nil, fmt.Errorf("error discarding content of %s: %w", rdr.name, err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("error reading the next header: %w", err)
+	}
+
+	hdr, err := DecodeHeader(&contextReader{ctx: ctx, r: rdr.r})
 	if err != nil {
 		return nil, fmt.Errorf("error reading the next header: %w", err)
 	}
 
+	rdr.name = hdr.Name
 	rdr.contentReader.N = int64(hdr.Size)
+	rdr.start = rdr.offset + int64(EncodedHeaderSize(hdr.Name, hdr.Size, hdr.Data))
+	rdr.end = rdr.start + int64(hdr.Size)
+	rdr.offset = rdr.end
+	rdr.ref = nil
+	rdr.sparse = nil
+
+	if ra, ok := rdr.r.(io.ReaderAt); ok && EntryType(hdr.Data) == EntryTypeRef {
+		resolved, offset, err := resolveReference(hdr)
+		if err != nil {
+			return nil, err
+		}
+		hdr = resolved
+		rdr.start = offset
+		rdr.end = offset + int64(hdr.Size)
+		rdr.ref = io.NewSectionReader(ra, rdr.start, int64(hdr.Size))
+	}
+
+	// unresolved references keep the sparse map of their content, but store nothing themselves
+	if EntryType(hdr.Data) == EntryTypeRef {
+		return hdr, nil
+	}
+
+	segs, size, sparse, err := expandSparse(hdr.Data, hdr.Size)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", hdr.Name, err)
+	}
+	if sparse {
+		var stored io.Reader = &rdr.contentReader
+		if rdr.ref != nil {
+			stored = rdr.ref
+		}
+		rdr.sparse = &sparseReader{
+			r:    stored,
+			segs: segs,
+			size: size,
+		}
+		hdr.Size = uint64(size)
+	}
 
 	return hdr, nil
 }
 
 func (rdr *reader) Read(b []byte) (int, error) {
+	if rdr.sparse != nil {
+		return rdr.sparse.Read(b)
+	}
+	if rdr.ref != nil {
+		return rdr.ref.Read(b)
+	}
 	return rdr.contentReader.Read(b)
 }
 
+func (rdr *reader) skipped() int64 {
+	return rdr.skip
+}
+
+// contentRange returns where the stored content of the current entry lies within the archive.
+func (rdr *reader) contentRange() (start, end int64) {
+	return rdr.start, rdr.end
+}
+
 func (rdr *reader) discardContent() error {
+	return rdr.discardContentContext(context.Background())
+}
+
+func (rdr *reader) discardContentContext(ctx context.Context) error {
 	var (
 		r = rdr.r
 		n = rdr.contentReader.N
@@ -69,7 +254,7 @@ func (rdr *reader) discardContent() error {
 		return err
 	}
 
-	_, err := io.CopyN(io.Discard, r, n)
+	_, err := io.CopyN(io.Discard, &contextReader{ctx: ctx, r: r}, n)
 	if errors.Is(err, io.EOF) {
 		return nil
 	}
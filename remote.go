@@ -0,0 +1,304 @@
+package pitch
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultBlockSize is the number of bytes an HTTPReaderAt fetches at a time when no block size is set.
+	DefaultBlockSize = 64 << 10
+	// DefaultCacheBlocks is the number of blocks an HTTPReaderAt keeps when no cache size is set.
+	DefaultCacheBlocks = 64
+)
+
+// ErrRangeNotSupported is returned when a server answers a range request with something other than partial content.
+var ErrRangeNotSupported = errors.New("pitch: server does not support range requests")
+
+// HTTPReaderAtOptions configures an HTTPReaderAt.
+// A nil *HTTPReaderAtOptions is equivalent to the zero value.
+type HTTPReaderAtOptions struct {
+	// Client sends the requests; http.DefaultClient is used if nil.
+	Client *http.Client
+	// BlockSize is the number of bytes fetched at a time, DefaultBlockSize if 0.
+	BlockSize int64
+	// CacheBlocks is the number of recently used blocks kept in memory, DefaultCacheBlocks if 0.
+	CacheBlocks int
+}
+
+// HTTPReaderAt is an io.ReaderAt over a remote file, backed by HTTP Range requests.
+// Reads are rounded out to whole blocks, which are cached, and runs of missing blocks
+// are fetched with a single request. It is safe for concurrent use.
+//
+// Together with a table of contents, single entries of a remote archive can be read
+// without downloading all of it:
+//
+//	ra, err := NewHTTPReaderAt(ctx, url, nil)
+//	...
+//	toc, err := BuildTableOfContents(io.NewSectionReader(ra, 0, ra.Size()))
+//	...
+//	content := toc["docs/index.html"].Content(ra)
+type HTTPReaderAt struct {
+	client      *http.Client
+	url         string
+	etag        string
+	size        int64
+	blockSize   int64
+	cacheBlocks int
+
+	mu sync.Mutex
+	// blocks maps block indices to their elements in lru, which holds *cachedBlock values.
+	blocks map[int64]*list.Element
+	lru    *list.List
+}
+
+type cachedBlock struct {
+	index int64
+	data  []byte
+}
+
+// NewHTTPReaderAt returns an HTTPReaderAt for the file at url.
+// The first block is fetched straight away, bound to ctx, to learn the size of the file.
+// Later requests are bound to the context given to ReadAtContext.
+//
+// If the server sends a strong ETag, later requests are made conditional on it,
+// so reads fail instead of mixing the contents of different versions of the file.
+func NewHTTPReaderAt(ctx context.Context, url string, opts *HTTPReaderAtOptions) (*HTTPReaderAt, error) {
+	hra := HTTPReaderAt{
+		client:      http.DefaultClient,
+		url:         url,
+		size:        -1,
+		blockSize:   DefaultBlockSize,
+		cacheBlocks: DefaultCacheBlocks,
+		blocks:      make(map[int64]*list.Element),
+		lru:         list.New(),
+	}
+	if opts != nil {
+		if opts.Client != nil {
+			hra.client = opts.Client
+		}
+		if 0 < opts.BlockSize {
+			hra.blockSize = opts.BlockSize
+		}
+		if 0 < opts.CacheBlocks {
+			hra.cacheBlocks = opts.CacheBlocks
+		}
+	}
+
+	data, err := hra.fetch(ctx, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	hra.store(0, data)
+
+	return &hra, nil
+}
+
+// Size returns the size of the remote file.
+func (hra *HTTPReaderAt) Size() int64 {
+	return hra.size
+}
+
+// ReadAt implements io.ReaderAt.
+func (hra *HTTPReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return hra.ReadAtContext(context.Background(), p, off)
+}
+
+// ReadAtContext is like ReadAt but binds the requests it makes to ctx.
+func (hra *HTTPReaderAt) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("pitch: invalid offset %d", off)
+	}
+	if hra.size <= off {
+		return 0, io.EOF
+	}
+
+	want := int64(len(p))
+	if hra.size-off < want {
+		want = hra.size - off
+	}
+	lastNeeded := (off + want - 1) / hra.blockSize
+
+	var n int64
+	for n < want {
+		idx := (off + n) / hra.blockSize
+
+		data, ok := hra.cached(idx)
+		if !ok {
+			// fetch every missing block up to the next cached one at once
+			last := idx
+			for last < lastNeeded && !hra.has(last+1) {
+				last++
+			}
+
+			var err error
+			data, err = hra.fetch(ctx, idx, last)
+			if err != nil {
+				return int(n), err
+			}
+			hra.store(idx, data)
+		}
+
+		start := off + n - idx*hra.blockSize
+		if int64(len(data)) <= start {
+			return int(n), io.ErrUnexpectedEOF
+		}
+		n += int64(copy(p[n:want], data[start:]))
+	}
+
+	if want < int64(len(p)) {
+		return int(n), io.EOF
+	}
+	return int(n), nil
+}
+
+func (hra *HTTPReaderAt) cached(idx int64) ([]byte, bool) {
+	hra.mu.Lock()
+	defer hra.mu.Unlock()
+
+	e, ok := hra.blocks[idx]
+	if !ok {
+		return nil, false
+	}
+	hra.lru.MoveToFront(e)
+	return e.Value.(*cachedBlock).data, true
+}
+
+func (hra *HTTPReaderAt) has(idx int64) bool {
+	hra.mu.Lock()
+	defer hra.mu.Unlock()
+
+	_, ok := hra.blocks[idx]
+	return ok
+}
+
+// store caches data, which holds consecutive blocks starting with block first.
+func (hra *HTTPReaderAt) store(first int64, data []byte) {
+	hra.mu.Lock()
+	defer hra.mu.Unlock()
+
+	for idx := first; 0 < len(data); idx++ {
+		var block = data
+		if hra.blockSize < int64(len(block)) {
+			block = block[:hra.blockSize]
+		}
+		data = data[len(block):]
+
+		if e, ok := hra.blocks[idx]; ok {
+			hra.lru.MoveToFront(e)
+			continue
+		}
+		hra.blocks[idx] = hra.lru.PushFront(&cachedBlock{
+			index: idx,
+			data:  block,
+		})
+	}
+
+	for hra.cacheBlocks < hra.lru.Len() {
+		e := hra.lru.Back()
+		hra.lru.Remove(e)
+		delete(hra.blocks, e.Value.(*cachedBlock).index)
+	}
+}
+
+// fetch returns the contents of the blocks first through last.
+// The first fetch learns the size of the file; an empty file has no blocks to fetch.
+func (hra *HTTPReaderAt) fetch(ctx context.Context, first, last int64) ([]byte, error) {
+	var (
+		start = first * hra.blockSize
+		end   = (last+1)*hra.blockSize - 1
+	)
+	if 0 <= hra.size && hra.size <= end {
+		end = hra.size - 1
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hra.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if hra.etag != "" {
+		req.Header.Set("If-Match", hra.etag)
+	}
+
+	resp, err := hra.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching bytes %d-%d: %w", start, end, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		// no range of an empty file can be satisfied
+		if start != 0 || 0 <= hra.size {
+			return nil, fmt.Errorf("error fetching bytes %d-%d: unexpected status %s", start, end, resp.Status)
+		}
+		hra.size = 0
+		return nil, nil
+	case http.StatusOK:
+		return nil, ErrRangeNotSupported
+	default:
+		return nil, fmt.Errorf("error fetching bytes %d-%d: unexpected status %s", start, end, resp.Status)
+	}
+
+	rangeStart, rangeEnd, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+	if rangeStart != start {
+		return nil, fmt.Errorf("error fetching bytes %d-%d: got bytes %d-%d", start, end, rangeStart, rangeEnd)
+	}
+	if hra.size < 0 {
+		hra.size = size
+		if etag := resp.Header.Get("ETag"); !strings.HasPrefix(etag, "W/") {
+			hra.etag = etag
+		}
+	}
+
+	data := make([]byte, rangeEnd-rangeStart+1)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, fmt.Errorf("error fetching bytes %d-%d: %w", start, end, err)
+	}
+	return data, nil
+}
+
+// parseContentRange parses a Content-Range header of the form "bytes start-end/size".
+func parseContentRange(s string) (start, end, size int64, err error) {
+	var (
+		spec, ok = strings.CutPrefix(s, "bytes ")
+		rng, sz  string
+		first    string
+		second   string
+	)
+	if ok {
+		rng, sz, ok = strings.Cut(spec, "/")
+	}
+	if ok {
+		first, second, ok = strings.Cut(rng, "-")
+	}
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q: %w", s, err)
+	}
+	if end, err = strconv.ParseInt(second, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q: %w", s, err)
+	}
+	if size, err = strconv.ParseInt(sz, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q: %w", s, err)
+	}
+	if end < start || size <= end {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	return start, end, size, nil
+}
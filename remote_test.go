@@ -0,0 +1,143 @@
+package pitch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (cw countingWriter) Write(b []byte) (int, error) {
+	cw.n.Add(int64(len(b)))
+	return cw.ResponseWriter.Write(b)
+}
+
+func TestHTTPReaderAt(t *testing.T) {
+	var (
+		is      = is.New(t)
+		buf     = bytes.NewBuffer(nil)
+		w       = NewWriter(buf)
+		served  atomic.Int64
+		version = "v1"
+	)
+
+	for i := range 20 {
+		content := strings.Repeat(fmt.Sprintf("%02d", i), 50<<10)
+		_, err := w.WriteHeader(fmt.Sprintf("file%02d.txt", i), int64(len(content)), nil)
+		is.NoErr(err)
+		_, err = w.Write([]byte(content))
+		is.NoErr(err)
+	}
+	is.NoErr(w.Close())
+	archive := buf.Bytes()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"`+version+`"`)
+		http.ServeContent(countingWriter{w, &served}, r, "archive.pch", time.Time{}, bytes.NewReader(archive))
+	}))
+	defer srv.Close()
+
+	ra, err := NewHTTPReaderAt(context.Background(), srv.URL, &HTTPReaderAtOptions{
+		BlockSize:   4 << 10,
+		CacheBlocks: 8,
+	})
+	is.NoErr(err)
+	is.Equal(ra.Size(), int64(len(archive)))
+
+	toc, err := BuildTableOfContents(io.NewSectionReader(ra, 0, ra.Size()))
+	is.NoErr(err)
+	is.Equal(len(toc), 20)
+
+	content, err := io.ReadAll(toc["file07.txt"].Content(ra))
+	is.NoErr(err)
+	is.Equal(string(content), strings.Repeat("07", 50<<10))
+
+	// only the headers and a single file were fetched
+	is.True(served.Load() < int64(len(archive))/5)
+
+	// reads past the end are short
+	b := make([]byte, 10)
+	n, err := ra.ReadAt(b, ra.Size()-4)
+	is.Equal(n, 4)
+	is.True(errors.Is(err, io.EOF))
+
+	// reads fail once the remote file changes
+	version = "v2"
+	_, err = io.ReadAll(toc["file12.txt"].Content(ra))
+	is.True(err != nil)
+}
+
+func TestHTTPReaderAt_NoRanges(t *testing.T) {
+	var is = is.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "no ranges here")
+	}))
+	defer srv.Close()
+
+	_, err := NewHTTPReaderAt(context.Background(), srv.URL, nil)
+	is.True(errors.Is(err, ErrRangeNotSupported))
+}
+
+func TestHTTPReaderAt_Empty(t *testing.T) {
+	var is = is.New(t)
+
+	// no range of an empty file is satisfiable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes */0")
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+	}))
+	defer srv.Close()
+
+	ra, err := NewHTTPReaderAt(context.Background(), srv.URL, nil)
+	is.NoErr(err)
+	is.Equal(ra.Size(), int64(0))
+
+	_, err = ra.ReadAt(make([]byte, 1), 0)
+	is.True(errors.Is(err, io.EOF))
+}
+
+func TestHTTPReaderAt_ReadAtContext(t *testing.T) {
+	var (
+		is      = is.New(t)
+		archive = []byte(strings.Repeat("a", 4<<10))
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "archive.pch", time.Time{}, bytes.NewReader(archive))
+	}))
+	defer srv.Close()
+
+	ra, err := NewHTTPReaderAt(context.Background(), srv.URL, &HTTPReaderAtOptions{
+		BlockSize: 1 << 10,
+	})
+	is.NoErr(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the first block is cached, the others have to be fetched
+	b := make([]byte, 1<<10)
+	_, err = ra.ReadAtContext(ctx, b, 0)
+	is.NoErr(err)
+	_, err = ra.ReadAtContext(ctx, b, 2<<10)
+	is.True(errors.Is(err, context.Canceled))
+
+	_, err = ra.ReadAt(b, 2<<10)
+	is.NoErr(err)
+}
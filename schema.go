@@ -0,0 +1,323 @@
+package pitch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidData is returned when header data does not match a schema or cannot be parsed as the type asked for.
+	ErrInvalidData = errors.New("pitch: invalid header data")
+	// ErrDataNotFound is returned when a header has no value for the key asked for.
+	ErrDataNotFound = errors.New("pitch: header data not found")
+)
+
+// reservedKeyPrefix prefixes the header data keys reserved by the pitch package, see DataKeyType and friends.
+const reservedKeyPrefix = "Pitch-"
+
+// DataType is the type of the values of a header data key.
+type DataType int
+
+const (
+	// DataTypeString values are arbitrary strings.
+	DataTypeString DataType = iota
+	// DataTypeInt values are base 10 integers, see Header.SetInt.
+	DataTypeInt
+	// DataTypeTime values are RFC 3339 timestamps, see Header.SetTime.
+	DataTypeTime
+	// DataTypeBool values are "true" or "false", see Header.SetBool.
+	DataTypeBool
+	// DataTypeBytes values are arbitrary bytes, see Header.SetBytes.
+	DataTypeBytes
+	// DataTypeEnum values are one of the strings listed by KeySchema.Enum.
+	DataTypeEnum
+)
+
+func (t DataType) String() string {
+	switch t {
+	case DataTypeString:
+		return "string"
+	case DataTypeInt:
+		return "int"
+	case DataTypeTime:
+		return "time"
+	case DataTypeBool:
+		return "bool"
+	case DataTypeBytes:
+		return "bytes"
+	case DataTypeEnum:
+		return "enum"
+	}
+	return fmt.Sprintf("DataType(%d)", int(t))
+}
+
+// Cardinality is the number of values a header data key may have.
+type Cardinality int
+
+const (
+	// CardinalityOptional keys have at most one value.
+	CardinalityOptional Cardinality = iota
+	// CardinalityOne keys have exactly one value.
+	CardinalityOne
+	// CardinalityMany keys have any number of values.
+	CardinalityMany
+	// CardinalityAtLeastOne keys have one or more values.
+	CardinalityAtLeastOne
+)
+
+func (c Cardinality) allows(n int) bool {
+	switch c {
+	case CardinalityOptional:
+		return n <= 1
+	case CardinalityOne:
+		return n == 1
+	case CardinalityAtLeastOne:
+		return 1 <= n
+	}
+	return true
+}
+
+func (c Cardinality) String() string {
+	switch c {
+	case CardinalityOptional:
+		return "at most one value"
+	case CardinalityOne:
+		return "exactly one value"
+	case CardinalityMany:
+		return "any number of values"
+	case CardinalityAtLeastOne:
+		return "at least one value"
+	}
+	return fmt.Sprintf("Cardinality(%d)", int(c))
+}
+
+// KeySchema describes the values of a header data key.
+type KeySchema struct {
+	Type        DataType
+	Cardinality Cardinality
+	// Enum lists the allowed values of DataTypeEnum keys.
+	Enum []string
+}
+
+// Schema is a registry of header data keys and the values they take.
+// Keys are registered up front, after which a Schema is safe for concurrent use.
+//
+// Keys missing from a schema are not checked unless Strict is set.
+// Keys reserved by the pitch package, such as DataKeyType, are never checked.
+type Schema struct {
+	// Strict rejects header data with keys that are not registered.
+	Strict bool
+	keys   map[string]KeySchema
+}
+
+// NewSchema returns an empty schema.
+func NewSchema() *Schema {
+	return &Schema{
+		keys: make(map[string]KeySchema),
+	}
+}
+
+// Register adds key to s.
+// It fails if key is already registered, is reserved by the pitch package or ks is invalid.
+func (s *Schema) Register(key string, ks KeySchema) error {
+	switch {
+	case key == "":
+		return errors.New("pitch: empty schema key")
+	case strings.HasPrefix(key, reservedKeyPrefix):
+		return fmt.Errorf("pitch: schema key %s is reserved", key)
+	case ks.Type < DataTypeString || DataTypeEnum < ks.Type:
+		return fmt.Errorf("pitch: schema key %s has an invalid type: %s", key, ks.Type)
+	case ks.Cardinality < CardinalityOptional || CardinalityAtLeastOne < ks.Cardinality:
+		return fmt.Errorf("pitch: schema key %s has an invalid cardinality: %s", key, ks.Cardinality)
+	case ks.Type == DataTypeEnum && len(ks.Enum) == 0:
+		return fmt.Errorf("pitch: schema key %s is an enum without values", key)
+	case ks.Type != DataTypeEnum && len(ks.Enum) != 0:
+		return fmt.Errorf("pitch: schema key %s lists values but is not an enum", key)
+	}
+
+	if _, ok := s.keys[key]; ok {
+		return fmt.Errorf("pitch: schema key %s is already registered", key)
+	}
+	ks.Enum = slices.Clone(ks.Enum)
+	s.keys[key] = ks
+	return nil
+}
+
+// Lookup returns the schema of key, if it is registered.
+func (s *Schema) Lookup(key string) (KeySchema, bool) {
+	ks, ok := s.keys[key]
+	return ks, ok
+}
+
+// Validate checks data against s. The returned error wraps ErrInvalidData
+// and describes every key that does not match.
+func (s *Schema) Validate(data map[string][]string) error {
+	var errs []error
+
+	keys := slices.Collect(maps.Keys(s.keys))
+	for k := range data {
+		if _, ok := s.keys[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		if strings.HasPrefix(key, reservedKeyPrefix) {
+			continue
+		}
+
+		values := data[key]
+		ks, ok := s.keys[key]
+		if !ok {
+			if s.Strict {
+				errs = append(errs, fmt.Errorf("%w: unknown key %s", ErrInvalidData, key))
+			}
+			continue
+		}
+
+		if !ks.Cardinality.allows(len(values)) {
+			errs = append(errs, fmt.Errorf("%w: %s has %d values, want %s", ErrInvalidData, key, len(values), ks.Cardinality))
+			continue
+		}
+		for _, v := range values {
+			if err := ks.check(v); err != nil {
+				errs = append(errs, fmt.Errorf("%w: %s: %w", ErrInvalidData, key, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// check checks a single value against ks.
+func (ks KeySchema) check(v string) error {
+	var err error
+	switch ks.Type {
+	case DataTypeInt:
+		_, err = strconv.ParseInt(v, 10, 64)
+	case DataTypeTime:
+		_, err = ParseModTime(v)
+	case DataTypeBool:
+		_, err = strconv.ParseBool(v)
+	case DataTypeEnum:
+		if !slices.Contains(ks.Enum, v) {
+			err = fmt.Errorf("%q is not one of %q", v, ks.Enum)
+		}
+	}
+	return err
+}
+
+// SetSchema makes the writer check the data of every entry it writes against s.
+// Entries whose data does not match are not written. A nil s turns checking off.
+func (wtr *Writer) SetSchema(s *Schema) {
+	wtr.schema = s
+}
+
+// NewValidatingReader is like NewReader, but the data of every entry is checked against s.
+// Next fails for entries whose data does not match; reading may go on with the entry after.
+func NewValidatingReader(r io.Reader, s *Schema) Reader {
+	rdr := NewReader(r).(*reader)
+	rdr.schema = s
+	return rdr
+}
+
+// Value returns the first value of key.
+func (h *Header) Value(key string) (string, bool) {
+	if v := h.Data[key]; 0 < len(v) {
+		return v[0], true
+	}
+	return "", false
+}
+
+// SetValue replaces the values of key with values.
+func (h *Header) SetValue(key string, values ...string) {
+	if h.Data == nil {
+		h.Data = make(map[string][]string)
+	}
+	h.Data[key] = values
+}
+
+// Int returns the first value of key as a DataTypeInt.
+func (h *Header) Int(key string) (int64, error) {
+	v, err := h.value(key)
+	if err != nil {
+		return 0, err
+	}
+	x, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %w", ErrInvalidData, key, err)
+	}
+	return x, nil
+}
+
+// SetInt sets the value of key to x, as a DataTypeInt.
+func (h *Header) SetInt(key string, x int64) {
+	h.SetValue(key, strconv.FormatInt(x, 10))
+}
+
+// Time returns the first value of key as a DataTypeTime.
+func (h *Header) Time(key string) (time.Time, error) {
+	v, err := h.value(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := ParseModTime(v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s: %w", ErrInvalidData, key, err)
+	}
+	return t, nil
+}
+
+// SetTime sets the value of key to t, as a DataTypeTime.
+func (h *Header) SetTime(key string, t time.Time) {
+	h.SetValue(key, FormatModTime(t))
+}
+
+// Bool returns the first value of key as a DataTypeBool.
+func (h *Header) Bool(key string) (bool, error) {
+	v, err := h.value(key)
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s: %w", ErrInvalidData, key, err)
+	}
+	return b, nil
+}
+
+// SetBool sets the value of key to b, as a DataTypeBool.
+func (h *Header) SetBool(key string, b bool) {
+	h.SetValue(key, strconv.FormatBool(b))
+}
+
+// Bytes returns the first value of key as a DataTypeBytes.
+func (h *Header) Bytes(key string) ([]byte, error) {
+	v, err := h.value(key)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(v), nil
+}
+
+// SetBytes sets the value of key to b, as a DataTypeBytes.
+// Values are stored as they are, header encoding is byte-safe.
+// See SetBytesValues for several values.
+func (h *Header) SetBytes(key string, b []byte) {
+	h.SetValue(key, string(b))
+}
+
+func (h *Header) value(key string) (string, error) {
+	v, ok := h.Value(key)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrDataNotFound, key)
+	}
+	return v, nil
+}
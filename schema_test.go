@@ -0,0 +1,149 @@
+package pitch
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func newTestSchema(t *testing.T) *Schema {
+	var (
+		is = is.New(t)
+		s  = NewSchema()
+	)
+	is.NoErr(s.Register("Build-Id", KeySchema{Type: DataTypeInt, Cardinality: CardinalityOne}))
+	is.NoErr(s.Register("Built-At", KeySchema{Type: DataTypeTime}))
+	is.NoErr(s.Register("Tags", KeySchema{Cardinality: CardinalityMany}))
+	is.NoErr(s.Register("Stage", KeySchema{Type: DataTypeEnum, Enum: []string{"dev", "prod"}}))
+	return s
+}
+
+func TestSchema_Register(t *testing.T) {
+	var (
+		is = is.New(t)
+		s  = newTestSchema(t)
+	)
+
+	is.True(s.Register("Build-Id", KeySchema{}) != nil)                       // already registered
+	is.True(s.Register(DataKeyMode, KeySchema{}) != nil)                      // reserved
+	is.True(s.Register("Level", KeySchema{Type: DataTypeEnum}) != nil)        // no values
+	is.True(s.Register("Size", KeySchema{Enum: []string{"small"}}) != nil)    // not an enum
+	is.True(s.Register("Odd", KeySchema{Cardinality: Cardinality(9)}) != nil) // invalid cardinality
+
+	ks, ok := s.Lookup("Stage")
+	is.True(ok)
+	is.Equal(ks.Type, DataTypeEnum)
+}
+
+func TestSchema_Validate(t *testing.T) {
+	s := newTestSchema(t)
+
+	tests := []struct {
+		name  string
+		data  map[string][]string
+		valid bool
+	}{
+		{name: "valid", data: map[string][]string{"Build-Id": {"42"}, "Stage": {"prod"}, "Tags": {"a", "b"}}, valid: true},
+		{name: "reserved keys are ignored", data: map[string][]string{"Build-Id": {"42"}, DataKeyMode: {"bogus"}}, valid: true},
+		{name: "unknown keys are allowed", data: map[string][]string{"Build-Id": {"42"}, "Owner": {"team"}}, valid: true},
+		{name: "missing required", data: map[string][]string{"Stage": {"dev"}}},
+		{name: "not an int", data: map[string][]string{"Build-Id": {"forty-two"}}},
+		{name: "not a time", data: map[string][]string{"Build-Id": {"1"}, "Built-At": {"yesterday"}}},
+		{name: "too many values", data: map[string][]string{"Build-Id": {"1", "2"}}},
+		{name: "not in enum", data: map[string][]string{"Build-Id": {"1"}, "Stage": {"qa"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			err := s.Validate(tt.data)
+			if tt.valid {
+				is.NoErr(err)
+				return
+			}
+			is.True(errors.Is(err, ErrInvalidData))
+		})
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		is := is.New(t)
+		strict := newTestSchema(t)
+		strict.Strict = true
+		is.True(errors.Is(strict.Validate(map[string][]string{"Build-Id": {"1"}, "Owner": {"team"}}), ErrInvalidData))
+		is.NoErr(strict.Validate(map[string][]string{"Build-Id": {"1"}, DataKeyMode: {"0644"}}))
+	})
+}
+
+func TestSchema_WriterAndReader(t *testing.T) {
+	var (
+		is  = is.New(t)
+		s   = newTestSchema(t)
+		buf = bytes.NewBuffer(nil)
+		w   = NewWriter(buf)
+	)
+
+	// unchecked, so that the reader has something to reject
+	_, err := w.WriteHeader("bad", 0, map[string][]string{"Build-Id": {"x"}})
+	is.NoErr(err)
+
+	w.SetSchema(s)
+	_, err = w.WriteHeader("rejected", 0, map[string][]string{"Build-Id": {"x"}})
+	is.True(errors.Is(err, ErrInvalidData))
+	_, err = w.WriteHeader("good", 0, map[string][]string{"Build-Id": {"7"}})
+	is.NoErr(err)
+	is.NoErr(w.Close())
+
+	r := NewValidatingReader(bytes.NewReader(buf.Bytes()), s)
+	_, err = r.Next()
+	is.True(errors.Is(err, ErrInvalidData))
+	hdr, err := r.Next()
+	is.NoErr(err)
+	is.Equal(hdr.Name, "good")
+	_, err = r.Next()
+	is.True(errors.Is(err, io.EOF))
+}
+
+func TestHeader_TypedData(t *testing.T) {
+	var (
+		is  = is.New(t)
+		hdr = Header{Name: "a.txt"}
+		now = time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC)
+	)
+
+	hdr.SetInt("Build-Id", -42)
+	hdr.SetTime("Built-At", now)
+	hdr.SetBool("Signed", true)
+	hdr.SetBytes("Hash", []byte{0, 0xff, 'a'})
+	hdr.SetValue("Tags", "a", "b")
+
+	n, err := hdr.Int("Build-Id")
+	is.NoErr(err)
+	is.Equal(n, int64(-42))
+	tm, err := hdr.Time("Built-At")
+	is.NoErr(err)
+	is.True(tm.Equal(now))
+	b, err := hdr.Bool("Signed")
+	is.NoErr(err)
+	is.True(b)
+	hash, err := hdr.Bytes("Hash")
+	is.NoErr(err)
+	is.Equal(hash, []byte{0, 0xff, 'a'})
+	v, ok := hdr.Value("Tags")
+	is.True(ok)
+	is.Equal(v, "a")
+
+	_, err = hdr.Int("Missing")
+	is.True(errors.Is(err, ErrDataNotFound))
+	_, err = hdr.Bool("Tags")
+	is.True(errors.Is(err, ErrInvalidData))
+
+	// typed values survive encoding
+	decoded, err := DecodeHeader(bytes.NewReader(EncodeHeader(hdr)))
+	is.NoErr(err)
+	hash, err = decoded.Bytes("Hash")
+	is.NoErr(err)
+	is.Equal(hash, []byte{0, 0xff, 'a'})
+}
@@ -0,0 +1,343 @@
+package pitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// A table of contents can be stored on its own, in JSON or in a binary form:
+//
+//	table of contents: "PITCHTOC" | items (uvarint) | item...
+//	item:              name length (uvarint) | name | size (uvarint) | start (uvarint) |
+//	                   stored length (uvarint) | data length (uvarint) | data
+//	sidecar:           "PITCHSDC" | archive size (uvarint) | digest length (uvarint) | digest |
+//	                   tail digest length (uvarint) | tail digest | table of contents
+//
+// Items are sorted by name and data is encoded like it is in headers.
+const (
+	tocMagic     = "PITCHTOC"
+	sidecarMagic = "PITCHSDC"
+)
+
+// SidecarExt is the extension of sidecar files, which are named after their archive: archive.pch.idx.
+const SidecarExt = ".idx"
+
+// sidecarTailSize is the number of bytes at the end of an archive that Sidecar.Verify hashes.
+// It covers the index and the last entries, which change whenever an archive is appended to.
+const sidecarTailSize = 64 << 10
+
+// ErrSidecarMismatch is returned when a sidecar was not made for the archive it is checked against.
+var ErrSidecarMismatch = errors.New("pitch: sidecar does not match archive")
+
+// WriteTo writes toc to w in binary form, see ReadTableOfContents.
+// Use encoding/json for the JSON form.
+func (toc TableOfContents) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(appendTableOfContents(nil, toc))
+	return int64(n), err
+}
+
+// ReadTableOfContents reads a table of contents written by TableOfContents.WriteTo,
+// or one encoded as JSON.
+func ReadTableOfContents(r io.Reader) (TableOfContents, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(b, []byte(tocMagic)) {
+		var toc TableOfContents
+		if err := json.Unmarshal(b, &toc); err != nil {
+			return nil, fmt.Errorf("error decoding table of contents: %w", err)
+		}
+		return rekeyTableOfContents(toc)
+	}
+
+	toc, rest, err := decodeTableOfContents(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("pitch: trailing bytes after table of contents")
+	}
+	return toc, nil
+}
+
+// Sidecar is a table of contents kept in a file next to its archive, see SidecarExt.
+// It records the size and digests of the archive so that it is not trusted for another one.
+type Sidecar struct {
+	// ArchiveSize is the size of the archive in bytes.
+	ArchiveSize int64 `json:"archive_size" yaml:"archive_size"`
+	// ArchiveDigest is the SHA-256 digest of the archive, formatted like DataKeyDigest values.
+	ArchiveDigest string `json:"archive_digest" yaml:"archive_digest"`
+	// TailDigest is the SHA-256 digest of the last 64 KiB of the archive, or all of it if it is shorter.
+	TailDigest string `json:"tail_digest" yaml:"tail_digest"`
+	// TableOfContents is the table of contents of the archive.
+	TableOfContents TableOfContents `json:"toc" yaml:"toc"`
+}
+
+// NewSidecar builds the table of contents of the size bytes long archive read by ra and returns it in a Sidecar.
+func NewSidecar(ra io.ReaderAt, size int64) (*Sidecar, error) {
+	return NewSidecarContext(context.Background(), ra, size)
+}
+
+// NewSidecarContext is like NewSidecar but gives up once ctx is done.
+func NewSidecarContext(ctx context.Context, ra io.ReaderAt, size int64) (*Sidecar, error) {
+	toc, err := BuildTableOfContentsContext(ctx, io.NewSectionReader(ra, 0, size))
+	if err != nil {
+		return nil, fmt.Errorf("error building table of contents: %w", err)
+	}
+
+	digest, err := contentDigest(&contextReader{ctx: ctx, r: io.NewSectionReader(ra, 0, size)})
+	if err != nil {
+		return nil, fmt.Errorf("error hashing archive: %w", err)
+	}
+	tail, err := tailDigest(ra, size)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing archive: %w", err)
+	}
+
+	return &Sidecar{
+		ArchiveSize:     size,
+		ArchiveDigest:   digest,
+		TailDigest:      tail,
+		TableOfContents: toc,
+	}, nil
+}
+
+// WriteTo writes s to w in binary form, see ReadSidecar.
+// Use encoding/json for the JSON form.
+func (s *Sidecar) WriteTo(w io.Writer) (int64, error) {
+	b := []byte(sidecarMagic)
+	b = binary.AppendUvarint(b, uint64(s.ArchiveSize))
+	b = binary.AppendUvarint(b, uint64(len(s.ArchiveDigest)))
+	b = append(b, s.ArchiveDigest...)
+	b = binary.AppendUvarint(b, uint64(len(s.TailDigest)))
+	b = append(b, s.TailDigest...)
+	b = appendTableOfContents(b, s.TableOfContents)
+
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// ReadSidecar reads a sidecar written by Sidecar.WriteTo, or one encoded as JSON.
+// The sidecar is not checked against its archive, see Sidecar.Verify and LoadSidecar.
+func ReadSidecar(r io.Reader) (*Sidecar, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Sidecar
+	rest, ok := bytes.CutPrefix(b, []byte(sidecarMagic))
+	if !ok {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return nil, fmt.Errorf("error decoding sidecar: %w", err)
+		}
+		if s.TableOfContents, err = rekeyTableOfContents(s.TableOfContents); err != nil {
+			return nil, err
+		}
+		return &s, nil
+	}
+
+	size, rest, err := cutUvarint(rest)
+	if err != nil {
+		return nil, err
+	}
+	digest, rest, err := cutString(rest)
+	if err != nil {
+		return nil, err
+	}
+	tail, rest, err := cutString(rest)
+	if err != nil {
+		return nil, err
+	}
+	if s.TableOfContents, rest, err = decodeTableOfContents(rest); err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("pitch: trailing bytes after sidecar")
+	}
+
+	s.ArchiveSize = int64(size)
+	s.ArchiveDigest = digest
+	s.TailDigest = tail
+	return &s, nil
+}
+
+// Verify checks that s was made for the size bytes long archive read by ra,
+// by comparing the size of the archive and the digest of its last 64 KiB.
+// It reads no more than that however large the archive is, so it catches archives
+// that were replaced or appended to, but not ones changed in place earlier on;
+// VerifyDigest hashes the whole archive to catch those too.
+func (s *Sidecar) Verify(ra io.ReaderAt, size int64) error {
+	if size != s.ArchiveSize {
+		return fmt.Errorf("%w: archive is %d bytes long, sidecar expects %d", ErrSidecarMismatch, size, s.ArchiveSize)
+	}
+
+	tail, err := tailDigest(ra, size)
+	if err != nil {
+		return fmt.Errorf("error hashing archive: %w", err)
+	}
+	if tail != s.TailDigest {
+		return fmt.Errorf("%w: archive tail digest is %s, sidecar expects %s", ErrSidecarMismatch, tail, s.TailDigest)
+	}
+	return nil
+}
+
+// VerifyDigest is like Verify but also compares the digest of the whole archive.
+func (s *Sidecar) VerifyDigest(ra io.ReaderAt, size int64) error {
+	if err := s.Verify(ra, size); err != nil {
+		return err
+	}
+
+	digest, err := contentDigest(io.NewSectionReader(ra, 0, size))
+	if err != nil {
+		return fmt.Errorf("error hashing archive: %w", err)
+	}
+	if digest != s.ArchiveDigest {
+		return fmt.Errorf("%w: archive digest is %s, sidecar expects %s", ErrSidecarMismatch, digest, s.ArchiveDigest)
+	}
+	return nil
+}
+
+// LoadSidecar reads a sidecar from r and returns its table of contents
+// once it is verified against the size bytes long archive read by ra, see Sidecar.Verify.
+// Use ReadSidecar and Sidecar.VerifyDigest to hash the whole archive instead.
+func LoadSidecar(r io.Reader, ra io.ReaderAt, size int64) (TableOfContents, error) {
+	s, err := ReadSidecar(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Verify(ra, size); err != nil {
+		return nil, err
+	}
+	return s.TableOfContents, nil
+}
+
+// tailDigest returns the digest of the last 64 KiB of the size bytes long archive read by ra.
+func tailDigest(ra io.ReaderAt, size int64) (string, error) {
+	start := max(size-sidecarTailSize, 0)
+	return contentDigest(io.NewSectionReader(ra, start, size-start))
+}
+
+func appendTableOfContents(b []byte, toc TableOfContents) []byte {
+	names := make([]string, 0, len(toc))
+	for name := range toc {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var data bytes.Buffer
+	b = append(b, tocMagic...)
+	b = binary.AppendUvarint(b, uint64(len(names)))
+	for _, name := range names {
+		item := toc[name]
+		b = binary.AppendUvarint(b, uint64(len(name)))
+		b = append(b, name...)
+		b = binary.AppendUvarint(b, item.Size)
+		b = binary.AppendUvarint(b, uint64(item.Start))
+		b = binary.AppendUvarint(b, uint64(item.End-item.Start))
+
+		data.Reset()
+		encodeData(&data, item.Data)
+		b = binary.AppendUvarint(b, uint64(data.Len()))
+		b = append(b, data.Bytes()...)
+	}
+	return b
+}
+
+// decodeTableOfContents decodes the table of contents at the start of b and returns it with the bytes after it.
+func decodeTableOfContents(b []byte) (TableOfContents, []byte, error) {
+	b, ok := bytes.CutPrefix(b, []byte(tocMagic))
+	if !ok {
+		return nil, nil, errors.New("pitch: not a table of contents")
+	}
+
+	count, b, err := cutUvarint(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	// every item takes at least 6 bytes
+	if uint64(len(b))/6 < count {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+
+	toc := make(TableOfContents, count)
+	for range count {
+		var (
+			item   HeaderItem
+			start  uint64
+			length uint64
+			data   string
+		)
+		if item.Name, b, err = cutString(b); err != nil {
+			return nil, nil, err
+		}
+		if item.Size, b, err = cutUvarint(b); err != nil {
+			return nil, nil, err
+		}
+		if start, b, err = cutUvarint(b); err != nil {
+			return nil, nil, err
+		}
+		if length, b, err = cutUvarint(b); err != nil {
+			return nil, nil, err
+		}
+		if data, b, err = cutString(b); err != nil {
+			return nil, nil, err
+		}
+
+		if data != "" {
+			if item.Data, err = decodeData([]byte(data)); err != nil {
+				return nil, nil, fmt.Errorf("error decoding data of %s: %w", item.Name, err)
+			}
+		}
+		item.Start = int64(start)
+		item.End = item.Start + int64(length)
+		if item.Start < 0 || item.End < item.Start {
+			return nil, nil, fmt.Errorf("pitch: invalid range for %s", item.Name)
+		}
+		toc[item.Name] = &item
+	}
+	return toc, b, nil
+}
+
+// rekeyTableOfContents keys the items of a decoded table of contents by their names,
+// which JSON object keys cannot always hold.
+func rekeyTableOfContents(toc TableOfContents) (TableOfContents, error) {
+	rekeyed := make(TableOfContents, len(toc))
+	for key, item := range toc {
+		if item == nil {
+			return nil, fmt.Errorf("pitch: missing item for %s", key)
+		}
+		if item.End < item.Start {
+			return nil, fmt.Errorf("pitch: invalid range for %s", item.Name)
+		}
+		rekeyed[item.Name] = item
+	}
+	return rekeyed, nil
+}
+
+func cutUvarint(b []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	return v, b[n:], nil
+}
+
+func cutString(b []byte) (string, []byte, error) {
+	n, b, err := cutUvarint(b)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(b)) < n {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	return string(b[:n]), b[n:], nil
+}
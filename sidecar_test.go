@@ -0,0 +1,137 @@
+package pitch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestTableOfContents_WriteTo(t *testing.T) {
+	var (
+		is      = is.New(t)
+		archive = newCompactTestArchive(t)
+	)
+
+	toc, err := BuildTableOfContents(archive)
+	is.NoErr(err)
+	toc["bin\xff"] = &HeaderItem{Name: "bin\xff", Size: 1, Data: map[string][]string{"k\xfe": {"v\x00"}}, Start: 3, End: 4}
+
+	buf := bytes.NewBuffer(nil)
+	n, err := toc.WriteTo(buf)
+	is.NoErr(err)
+	is.Equal(n, int64(buf.Len()))
+
+	got, err := ReadTableOfContents(bytes.NewReader(buf.Bytes()))
+	is.NoErr(err)
+	is.Equal(got, toc)
+
+	j, err := json.Marshal(toc)
+	is.NoErr(err)
+	got, err = ReadTableOfContents(bytes.NewReader(j))
+	is.NoErr(err)
+	is.Equal(got, toc)
+
+	// truncated tables of contents are rejected
+	for _, l := range []int{len(tocMagic), buf.Len() / 2, buf.Len() - 1} {
+		_, err = ReadTableOfContents(bytes.NewReader(buf.Bytes()[:l]))
+		is.True(err != nil)
+	}
+	_, err = ReadTableOfContents(bytes.NewReader(append(buf.Bytes(), 0)))
+	is.True(err != nil)
+}
+
+func TestSidecar(t *testing.T) {
+	var (
+		is      = is.New(t)
+		archive = newCompactTestArchive(t)
+		ra      = bytes.NewReader(archive)
+		size    = int64(len(archive))
+	)
+
+	s, err := NewSidecar(ra, size)
+	is.NoErr(err)
+	is.Equal(s.ArchiveSize, size)
+	toc, err := BuildTableOfContents(archive)
+	is.NoErr(err)
+	is.Equal(s.TableOfContents, toc)
+
+	var (
+		bin = bytes.NewBuffer(nil)
+		j   []byte
+	)
+	_, err = s.WriteTo(bin)
+	is.NoErr(err)
+	j, err = json.Marshal(s)
+	is.NoErr(err)
+
+	for _, encoded := range [][]byte{bin.Bytes(), j} {
+		got, err := ReadSidecar(bytes.NewReader(encoded))
+		is.NoErr(err)
+		is.Equal(got, s)
+
+		loaded, err := LoadSidecar(bytes.NewReader(encoded), ra, size)
+		is.NoErr(err)
+		is.Equal(loaded, toc)
+
+		item := loaded["sparse"]
+		content, err := io.ReadAll(item.Content(ra))
+		is.NoErr(err)
+		is.Equal(string(content), "\x00\x00ABC\x00\x00\x00DE\x00\x00")
+	}
+
+	// sidecars are not trusted for other archives
+	_, err = LoadSidecar(bytes.NewReader(bin.Bytes()), ra, size-1)
+	is.True(errors.Is(err, ErrSidecarMismatch))
+
+	changed := bytes.Clone(archive)
+	changed[len(changed)/2] ^= 0xff
+	_, err = LoadSidecar(bytes.NewReader(bin.Bytes()), bytes.NewReader(changed), size)
+	is.True(errors.Is(err, ErrSidecarMismatch))
+	is.True(errors.Is(s.VerifyDigest(bytes.NewReader(changed), size), ErrSidecarMismatch))
+}
+
+func TestSidecar_VerifyTail(t *testing.T) {
+	var (
+		is      = is.New(t)
+		archive = writeTestArchive(t,
+			testEntry{name: "big", content: strings.Repeat("A", 4*sidecarTailSize)},
+			testEntry{name: "small", content: "BBB"},
+		)
+		size = int64(len(archive))
+	)
+
+	s, err := NewSidecar(bytes.NewReader(archive), size)
+	is.NoErr(err)
+
+	// only the tail is read by default
+	ra := &countingReaderAt{r: bytes.NewReader(archive)}
+	is.NoErr(s.Verify(ra, size))
+	is.True(ra.n <= sidecarTailSize)
+
+	// changes to the last 64 KiB are caught by default
+	changed := bytes.Clone(archive)
+	changed[len(changed)-sidecarTailSize/2] ^= 0xff
+	is.True(errors.Is(s.Verify(bytes.NewReader(changed), size), ErrSidecarMismatch))
+
+	// changes earlier on only by the full check
+	changed = bytes.Clone(archive)
+	changed[len(changed)/2] ^= 0xff
+	is.NoErr(s.Verify(bytes.NewReader(changed), size))
+	is.True(errors.Is(s.VerifyDigest(bytes.NewReader(changed), size), ErrSidecarMismatch))
+}
+
+type countingReaderAt struct {
+	r io.ReaderAt
+	n int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,275 @@
+package pitch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sparseSegment is a run of data within a sparse file; everything outside of its segments is a hole.
+type sparseSegment struct {
+	offset int64
+	length int64
+}
+
+// formatSparseMap formats segs the way they are stored under DataKeySparseMap.
+func formatSparseMap(segs []sparseSegment) []string {
+	values := make([]string, len(segs))
+	for i, seg := range segs {
+		values[i] = strconv.FormatInt(seg.offset, 10) + ":" + strconv.FormatInt(seg.length, 10)
+	}
+	return values
+}
+
+// parseSparseMap returns the data segments and logical size of the sparse file described by data.
+// ok is false if data does not describe a sparse file, that is if it has no DataKeySparseMap.
+func parseSparseMap(data map[string][]string) (segs []sparseSegment, size int64, ok bool, err error) {
+	values, hasMap := data[DataKeySparseMap]
+	if !hasMap {
+		return nil, 0, false, nil
+	}
+	sizes := data[DataKeySparseSize]
+	if len(sizes) == 0 {
+		return nil, 0, false, errors.New("sparse map without size")
+	}
+
+	if size, err = strconv.ParseInt(sizes[0], 10, 64); err != nil || size < 0 {
+		return nil, 0, false, fmt.Errorf("invalid sparse size %q", sizes[0])
+	}
+
+	var end int64
+	segs = make([]sparseSegment, len(values))
+	for i, v := range values {
+		off, length, found := strings.Cut(v, ":")
+		if !found {
+			return nil, 0, false, fmt.Errorf("invalid sparse segment %q", v)
+		}
+		seg := &segs[i]
+		if seg.offset, err = strconv.ParseInt(off, 10, 64); err != nil {
+			return nil, 0, false, fmt.Errorf("invalid sparse segment %q", v)
+		}
+		if seg.length, err = strconv.ParseInt(length, 10, 64); err != nil {
+			return nil, 0, false, fmt.Errorf("invalid sparse segment %q", v)
+		}
+		// segments must be ordered, disjoint and within the file
+		if seg.offset < end || seg.length < 0 || size < seg.offset+seg.length {
+			return nil, 0, false, fmt.Errorf("invalid sparse segment %q", v)
+		}
+		end = seg.offset + seg.length
+	}
+
+	return segs, size, true, nil
+}
+
+// sparseDataSize returns the number of data bytes in segs.
+func sparseDataSize(segs []sparseSegment) int64 {
+	var n int64
+	for _, seg := range segs {
+		n += seg.length
+	}
+	return n
+}
+
+// expandSparse returns the data segments and logical size of an entry of storedSize bytes with data
+// if it is a sparse file, that is if data has a DataKeySparseMap.
+func expandSparse(data map[string][]string, storedSize uint64) ([]sparseSegment, int64, bool, error) {
+	segs, size, ok, err := parseSparseMap(data)
+	if err != nil || !ok {
+		return nil, 0, false, err
+	}
+	if uint64(sparseDataSize(segs)) != storedSize {
+		return nil, 0, false, fmt.Errorf("sparse map does not match the size of the entry")
+	}
+	return segs, size, true, nil
+}
+
+// storedSize returns the number of content bytes stored for the entry described by hdr,
+// which is less than its size for sparse files handed out with their logical size.
+// Unresolved references store nothing, whatever their content is.
+func storedSize(hdr *Header) (uint64, error) {
+	if EntryType(hdr.Data) == EntryTypeRef {
+		return hdr.Size, nil
+	}
+	segs, _, ok, err := parseSparseMap(hdr.Data)
+	if err != nil || !ok {
+		return hdr.Size, err
+	}
+	return uint64(sparseDataSize(segs)), nil
+}
+
+// detectSparse returns the data segments of the size bytes long file f if it has holes, or nil otherwise.
+// A file that is a single hole has an empty, non-nil list of segments.
+// It moves the offset of f.
+func detectSparse(f *os.File, size int64) ([]sparseSegment, error) {
+	segs, err := dataSegments(f, size)
+	if err != nil {
+		return nil, err
+	}
+	if sparseDataSize(segs) == size {
+		return nil, nil
+	}
+	if segs == nil {
+		segs = []sparseSegment{}
+	}
+	return segs, nil
+}
+
+// sparseContent returns a reader over the data segments of f, in order.
+func sparseContent(f io.ReaderAt, segs []sparseSegment) io.Reader {
+	readers := make([]io.Reader, len(segs))
+	for i, seg := range segs {
+		readers[i] = io.NewSectionReader(f, seg.offset, seg.length)
+	}
+	return io.MultiReader(readers...)
+}
+
+// sparseReader reads the logical content of a sparse file from a reader over its data segments,
+// filling holes with zeros.
+type sparseReader struct {
+	r    io.Reader
+	segs []sparseSegment
+	size int64
+	pos  int64
+}
+
+func (sr *sparseReader) Read(b []byte) (int, error) {
+	if sr.size <= sr.pos {
+		return 0, io.EOF
+	}
+
+	for 0 < len(sr.segs) && sr.segs[0].offset+sr.segs[0].length <= sr.pos {
+		sr.segs = sr.segs[1:]
+	}
+
+	var next = sr.size
+	if 0 < len(sr.segs) {
+		if seg := sr.segs[0]; seg.offset <= sr.pos {
+			n := min(int64(len(b)), seg.offset+seg.length-sr.pos)
+			m, err := sr.r.Read(b[:n])
+			sr.pos += int64(m)
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return m, err
+		}
+		next = sr.segs[0].offset
+	}
+
+	n := min(int64(len(b)), next-sr.pos)
+	clear(b[:n])
+	sr.pos += n
+	return int(n), nil
+}
+
+// sparseReaderAt reads the logical content of a sparse file whose data segments
+// are stored back to back from base within ra, filling holes with zeros.
+type sparseReaderAt struct {
+	ra   io.ReaderAt
+	base int64
+	segs []sparseSegment
+	// stored holds the offset of every segment relative to base.
+	stored []int64
+	size   int64
+}
+
+func newSparseReaderAt(ra io.ReaderAt, base int64, segs []sparseSegment, size int64) *sparseReaderAt {
+	sra := sparseReaderAt{
+		ra:     ra,
+		base:   base,
+		segs:   segs,
+		stored: make([]int64, len(segs)),
+		size:   size,
+	}
+	var n int64
+	for i, seg := range segs {
+		sra.stored[i] = n
+		n += seg.length
+	}
+	return &sra
+}
+
+func (sra *sparseReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("pitch: invalid offset %d", off)
+	}
+
+	var n int
+	for n < len(b) && off < sra.size {
+		// the first segment that ends after off
+		i := sort.Search(len(sra.segs), func(i int) bool {
+			return off < sra.segs[i].offset+sra.segs[i].length
+		})
+
+		var next = sra.size
+		if i < len(sra.segs) {
+			seg := sra.segs[i]
+			if seg.offset <= off {
+				want := min(int64(len(b)-n), seg.offset+seg.length-off)
+				m, err := sra.ra.ReadAt(b[n:n+int(want)], sra.base+sra.stored[i]+off-seg.offset)
+				n += m
+				off += int64(m)
+				if err != nil && (!errors.Is(err, io.EOF) || int64(m) < want) {
+					if errors.Is(err, io.EOF) {
+						err = io.ErrUnexpectedEOF
+					}
+					return n, err
+				}
+				continue
+			}
+			next = seg.offset
+		}
+
+		want := min(int64(len(b)-n), next-off)
+		clear(b[n : n+int(want)])
+		n += int(want)
+		off += want
+	}
+
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// sparseWriter writes the logical content of a sparse file to f, skipping its holes.
+// Holes at the end of the file are left to a final Truncate.
+type sparseWriter struct {
+	f    *os.File
+	segs []sparseSegment
+	pos  int64
+}
+
+func (sw *sparseWriter) Write(b []byte) (int, error) {
+	var n int
+	for n < len(b) {
+		for 0 < len(sw.segs) && sw.segs[0].offset+sw.segs[0].length <= sw.pos {
+			sw.segs = sw.segs[1:]
+		}
+		if len(sw.segs) == 0 {
+			// the rest of the file is a hole
+			sw.pos += int64(len(b) - n)
+			return len(b), nil
+		}
+
+		seg := sw.segs[0]
+		if sw.pos < seg.offset {
+			skip := min(int64(len(b)-n), seg.offset-sw.pos)
+			n += int(skip)
+			sw.pos += skip
+			continue
+		}
+
+		want := min(int64(len(b)-n), seg.offset+seg.length-sw.pos)
+		m, err := sw.f.WriteAt(b[n:n+int(want)], sw.pos)
+		n += m
+		sw.pos += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
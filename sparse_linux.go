@@ -0,0 +1,54 @@
+//go:build linux
+
+package pitch
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// whence values for lseek, see lseek(2).
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// dataSegments returns the data segments of the first size bytes of f using SEEK_DATA and SEEK_HOLE.
+// File systems without hole support report all of f as data.
+func dataSegments(f *os.File, size int64) ([]sparseSegment, error) {
+	defer f.Seek(0, io.SeekStart)
+
+	var segs []sparseSegment
+	for off := int64(0); off < size; {
+		data, err := f.Seek(off, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			// only a hole is left
+			break
+		}
+		if errors.Is(err, syscall.EINVAL) {
+			return []sparseSegment{{0, size}}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if size <= data {
+			break
+		}
+
+		hole, err := f.Seek(data, seekHole)
+		if err != nil {
+			return nil, err
+		}
+		hole = min(hole, size)
+
+		segs = append(segs, sparseSegment{
+			offset: data,
+			length: hole - data,
+		})
+		off = hole
+	}
+
+	return segs, nil
+}
@@ -0,0 +1,279 @@
+package pitch
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestSparseReaders(t *testing.T) {
+	var (
+		is   = is.New(t)
+		segs = []sparseSegment{{2, 3}, {8, 2}}
+		// "ABC" at 2 and "DE" at 8, in a file of 12 bytes
+		stored  = "ABCDE"
+		logical = "\x00\x00ABC\x00\x00\x00DE\x00\x00"
+	)
+
+	content, err := io.ReadAll(&sparseReader{r: strings.NewReader(stored), segs: segs, size: 12})
+	is.NoErr(err)
+	is.Equal(string(content), logical)
+
+	sra := newSparseReaderAt(strings.NewReader("xx"+stored), 2, segs, 12)
+	for off := 0; off < len(logical); off++ {
+		for n := 1; off+n <= len(logical); n++ {
+			b := make([]byte, n)
+			m, err := sra.ReadAt(b, int64(off))
+			is.NoErr(err)
+			is.Equal(m, n)
+			is.Equal(string(b), logical[off:off+n])
+		}
+	}
+	b := make([]byte, 4)
+	m, err := sra.ReadAt(b, 10)
+	is.Equal(m, 2)
+	is.Equal(err, io.EOF)
+
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "sparse"))
+	is.NoErr(err)
+	w := &sparseWriter{f: f, segs: segs}
+	_, err = io.Copy(w, &oneByteReader{strings.NewReader(logical)})
+	is.NoErr(err)
+	is.NoErr(f.Truncate(12))
+	is.NoErr(f.Close())
+	written, err := os.ReadFile(filepath.Join(dir, "sparse"))
+	is.NoErr(err)
+	is.Equal(string(written), logical)
+}
+
+// oneByteReader reads a single byte at a time.
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (obr *oneByteReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	return obr.r.Read(b[:1])
+}
+
+func TestParseSparseMap(t *testing.T) {
+	var is = is.New(t)
+
+	segs, size, ok, err := parseSparseMap(map[string][]string{
+		DataKeySparseMap:  {"0:10", "20:5"},
+		DataKeySparseSize: {"30"},
+	})
+	is.NoErr(err)
+	is.True(ok)
+	is.Equal(size, int64(30))
+	is.Equal(segs, []sparseSegment{{0, 10}, {20, 5}})
+
+	_, _, ok, err = parseSparseMap(nil)
+	is.NoErr(err)
+	is.True(!ok)
+
+	// only the map makes an entry sparse
+	_, _, ok, err = parseSparseMap(map[string][]string{DataKeySparseSize: {"30"}})
+	is.NoErr(err)
+	is.True(!ok)
+
+	// even when it has no holes
+	_, size, ok, err = expandSparse(map[string][]string{
+		DataKeySparseMap:  {"0:30"},
+		DataKeySparseSize: {"30"},
+	}, 30)
+	is.NoErr(err)
+	is.True(ok)
+	is.Equal(size, int64(30))
+
+	_, _, _, err = expandSparse(map[string][]string{
+		DataKeySparseMap:  {"0:10", "20:5"},
+		DataKeySparseSize: {"30"},
+	}, 30)
+	is.True(err != nil)
+
+	for _, invalid := range [][]string{{"10:5", "0:5"}, {"0:50"}, {"x:1"}, {"5"}} {
+		_, _, _, err = parseSparseMap(map[string][]string{
+			DataKeySparseMap:  invalid,
+			DataKeySparseSize: {"30"},
+		})
+		is.True(err != nil)
+	}
+}
+
+func TestArchiveDir_Sparse(t *testing.T) {
+	var (
+		is   = is.New(t)
+		dir  = t.TempDir()
+		src  = filepath.Join(dir, "src")
+		size = int64(8 << 20)
+	)
+	is.NoErr(os.MkdirAll(src, 0o755))
+
+	f, err := os.Create(filepath.Join(src, "disk.img"))
+	is.NoErr(err)
+	is.NoErr(f.Truncate(size))
+	_, err = f.WriteAt([]byte("boot"), 0)
+	is.NoErr(err)
+	_, err = f.WriteAt([]byte("data"), 4<<20)
+	is.NoErr(err)
+	is.NoErr(f.Close())
+	logical, err := os.ReadFile(filepath.Join(src, "disk.img"))
+	is.NoErr(err)
+
+	for _, concurrency := range []int{1, 4} {
+		buf := bytes.NewBuffer(nil)
+		err = ArchiveDirWithOptions(&nopCloser{buf}, src, &ArchiveOptions{
+			Sparse:       true,
+			Dedup:        true,
+			Concurrency:  concurrency,
+			MemoryBudget: 1 << 20,
+		})
+		is.NoErr(err)
+		if int64(buf.Len()) > size {
+			t.Skip("the file system does not report holes")
+		}
+		archive := buf.Bytes()
+
+		contents, hdrs, err := readArchive(NewReader(bytes.NewReader(archive)))
+		is.NoErr(err)
+		is.Equal(hdrs["src/disk.img"].Size, uint64(size))
+		is.True(contents["src/disk.img"] == string(logical))
+
+		toc, err := BuildTableOfContents(archive)
+		is.NoErr(err)
+		item := toc["src/disk.img"]
+		is.Equal(item.Size, uint64(size))
+		is.True(item.End-item.Start < size)
+		content, err := io.ReadAll(item.Content(bytes.NewReader(archive)))
+		is.NoErr(err)
+		is.True(bytes.Equal(content, logical))
+
+		// readers that do not report content ranges still locate the stored data
+		catTOC, err := BuildTableOfContents(Cat(NewReader(bytes.NewReader(archive))))
+		is.NoErr(err)
+		is.Equal(*catTOC["src/disk.img"], *item)
+
+		out := t.TempDir()
+		err = ExtractAt(t.Context(), out, bytes.NewReader(archive), toc, nil)
+		is.NoErr(err)
+		extracted, err := os.ReadFile(filepath.Join(out, "src", "disk.img"))
+		is.NoErr(err)
+		is.True(bytes.Equal(extracted, logical))
+
+		// holes survive extraction
+		f, err = os.Open(filepath.Join(out, "src", "disk.img"))
+		is.NoErr(err)
+		segs, err := detectSparse(f, size)
+		is.NoErr(f.Close())
+		is.NoErr(err)
+		is.True(segs != nil)
+
+		out = t.TempDir()
+		err = Extract(out, NewReader(struct{ io.Reader }{bytes.NewReader(archive)}), nil)
+		is.NoErr(err)
+		extracted, err = os.ReadFile(filepath.Join(out, "src", "disk.img"))
+		is.NoErr(err)
+		is.True(bytes.Equal(extracted, logical))
+	}
+}
+
+func TestArchiveDir_SparseAllHole(t *testing.T) {
+	var (
+		is   = is.New(t)
+		dir  = t.TempDir()
+		src  = filepath.Join(dir, "src")
+		size = int64(4 << 20)
+	)
+	is.NoErr(os.MkdirAll(src, 0o755))
+
+	f, err := os.Create(filepath.Join(src, "zeros.img"))
+	is.NoErr(err)
+	is.NoErr(f.Truncate(size))
+	segs, err := dataSegments(f, size)
+	is.NoErr(f.Close())
+	is.NoErr(err)
+	if len(segs) != 0 {
+		t.Skip("the file system does not report holes")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = ArchiveDirWithOptions(&nopCloser{buf}, src, &ArchiveOptions{Sparse: true})
+	is.NoErr(err)
+	archive := buf.Bytes()
+	is.True(int64(len(archive)) < size)
+
+	toc, err := BuildTableOfContents(archive)
+	is.NoErr(err)
+	item := toc["src/zeros.img"]
+	is.Equal(item.Size, uint64(size))
+	is.Equal(item.End, item.Start) // nothing but the map is stored
+	is.Equal(item.Data[DataKeySparseSize], []string{strconv.FormatInt(size, 10)})
+
+	contents, _, err := readArchive(NewReader(bytes.NewReader(archive)))
+	is.NoErr(err)
+	is.True(contents["src/zeros.img"] == string(make([]byte, size)))
+
+	out := t.TempDir()
+	err = ExtractAt(t.Context(), out, bytes.NewReader(archive), toc, nil)
+	is.NoErr(err)
+	info, err := os.Stat(filepath.Join(out, "src", "zeros.img"))
+	is.NoErr(err)
+	is.Equal(info.Size(), size)
+}
+
+func TestArchiveDir_SparseDedupStream(t *testing.T) {
+	var (
+		is   = is.New(t)
+		dir  = t.TempDir()
+		src  = filepath.Join(dir, "src")
+		size = int64(4 << 20)
+	)
+	is.NoErr(os.MkdirAll(src, 0o755))
+
+	for _, name := range []string{"a.img", "b.img"} {
+		f, err := os.Create(filepath.Join(src, name))
+		is.NoErr(err)
+		is.NoErr(f.Truncate(size))
+		_, err = f.WriteAt([]byte("data"), 2<<20)
+		is.NoErr(err)
+		is.NoErr(f.Close())
+	}
+	logical, err := os.ReadFile(filepath.Join(src, "a.img"))
+	is.NoErr(err)
+
+	buf := bytes.NewBuffer(nil)
+	err = ArchiveDirWithOptions(&nopCloser{buf}, src, &ArchiveOptions{Sparse: true, Dedup: true})
+	is.NoErr(err)
+	archive := buf.Bytes()
+
+	// readers without random access hand the reference out as it is
+	stream := func() io.Reader { return struct{ io.Reader }{bytes.NewReader(archive)} }
+	_, hdrs, err := readArchive(NewReader(stream()))
+	is.NoErr(err)
+	is.Equal(EntryType(hdrs["src/b.img"].Data), EntryTypeRef)
+
+	toc, err := BuildTableOfContents(stream())
+	is.NoErr(err)
+	is.Equal(toc["src/b.img"].Size, uint64(size))
+	is.Equal(toc["src/b.img"].Start, toc["src/a.img"].Start)
+	is.Equal(toc["src/b.img"].End, toc["src/a.img"].End)
+
+	out := t.TempDir()
+	err = Extract(out, NewReader(stream()), nil)
+	is.NoErr(err)
+	for _, name := range []string{"a.img", "b.img"} {
+		extracted, err := os.ReadFile(filepath.Join(out, "src", name))
+		is.NoErr(err)
+		is.True(bytes.Equal(extracted, logical))
+	}
+}
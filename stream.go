@@ -0,0 +1,117 @@
+package pitch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// MediaType is the media type of pitch archives sent over HTTP.
+const MediaType = "application/x-pitch"
+
+// ArchiveHandler returns an http.Handler that streams a pitch archive of the directory in fsys
+// named by the request path; a request for "/" archives all of fsys.
+// Like ArchiveFS, header names keep the base name of the requested directory.
+//
+// Several directories can be requested at once with repeated path query parameters,
+// relative to the request path: "/src?path=cmd&path=docs" archives src/cmd and src/docs
+// into a single archive, with header names starting with "cmd" and "docs".
+//
+// The archive is built while it is sent, so nothing is buffered or written to disk.
+// If archiving fails part way through, the response is aborted rather than completed,
+// so clients never mistake a partial archive for a whole one.
+func ArchiveHandler(fsys fs.FS, opts *ArchiveOptions) http.Handler {
+	return &archiveHandler{
+		fsys: fsys,
+		opts: opts,
+	}
+}
+
+type archiveHandler struct {
+	fsys fs.FS
+	opts *ArchiveOptions
+}
+
+func (ah *archiveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var (
+		base  = fsPath(r.URL.Path)
+		roots = r.URL.Query()["path"]
+	)
+	if len(roots) == 0 {
+		roots = []string{base}
+	} else {
+		for i, p := range roots {
+			roots[i] = fsPath(path.Join(base, p))
+		}
+	}
+
+	for _, root := range roots {
+		if _, err := fs.Stat(ah.fsys, root); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	name := path.Base(base)
+	if len(roots) == 1 {
+		name = path.Base(roots[0])
+	}
+	if name == "." {
+		name = "archive"
+	}
+	w.Header().Set("Content-Type", MediaType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".pch"))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	err := archiveTrees(r.Context(), writeNopCloser{w}, roots, func(pw *Writer, root string) (*archiver, error) {
+		return newArchiver(pw, ah.fsys, root, ah.opts), nil
+	})
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// fsPath turns the slash separated path p into a valid fs.FS path that cannot leave the root.
+func fsPath(p string) string {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// ExtractResponse extracts the pitch archive in the body of resp into the directory dst
+// as it is received, see ExtractContext. The body is always closed.
+// Responses with a status other than 200 OK are rejected.
+func ExtractResponse(ctx context.Context, dst string, resp *http.Response, opts *ExtractOptions) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error fetching archive: unexpected status %s", resp.Status)
+	}
+
+	return ExtractContext(ctx, dst, NewReader(resp.Body), opts)
+}
+
+type writeNopCloser struct {
+	io.Writer
+}
+
+func (writeNopCloser) Close() error { return nil }
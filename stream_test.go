@@ -0,0 +1,103 @@
+package pitch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+func TestArchiveHandler(t *testing.T) {
+	var (
+		is   = is.New(t)
+		fsys = extractTestFS()
+		srv  = httptest.NewServer(ArchiveHandler(fsys, &ArchiveOptions{
+			Symlinks: SymlinkStore,
+			Metadata: true,
+		}))
+	)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/tree")
+	is.NoErr(err)
+	is.Equal(resp.Header.Get("Content-Type"), MediaType)
+	is.Equal(resp.Header.Get("Content-Disposition"), `attachment; filename="tree.pch"`)
+
+	dir := t.TempDir()
+	err = ExtractResponse(context.Background(), dir, resp, nil)
+	is.NoErr(err)
+	checkExtracted(is, dir, fsys)
+
+	// only the requested subset is sent
+	resp, err = http.Get(srv.URL + "/tree/bin")
+	is.NoErr(err)
+	dir = t.TempDir()
+	err = ExtractResponse(context.Background(), dir, resp, nil)
+	is.NoErr(err)
+	checkExtracted(is, dir, fstest.MapFS{"bin/run": fsys["tree/bin/run"]})
+
+	resp, err = http.Get(srv.URL + "/missing")
+	is.NoErr(err)
+	err = ExtractResponse(context.Background(), t.TempDir(), resp, nil)
+	is.True(err != nil)
+	is.Equal(resp.StatusCode, http.StatusNotFound)
+}
+
+func TestArchiveHandler_Paths(t *testing.T) {
+	var (
+		is   = is.New(t)
+		fsys = extractTestFS()
+		srv  = httptest.NewServer(ArchiveHandler(fsys, &ArchiveOptions{Metadata: true}))
+	)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/tree?path=bin&path=a.txt&path=../tree/many")
+	is.NoErr(err)
+	is.Equal(resp.Header.Get("Content-Disposition"), `attachment; filename="tree.pch"`)
+
+	dir := t.TempDir()
+	err = ExtractResponse(context.Background(), dir, resp, nil)
+	is.NoErr(err)
+
+	expected := fstest.MapFS{
+		"bin/run": fsys["tree/bin/run"],
+		"a.txt":   fsys["tree/a.txt"],
+	}
+	for name, file := range fsys {
+		if rest, ok := strings.CutPrefix(name, "tree/many/"); ok {
+			expected["many/"+rest] = file
+		}
+	}
+	checkExtracted(is, dir, expected)
+
+	entries, err := os.ReadDir(dir)
+	is.NoErr(err)
+	is.Equal(len(entries), 3) // a.txt, bin and many only
+
+	// every path must exist, and none may leave fsys
+	for _, query := range []string{"?path=bin&path=missing", "?path=../../etc"} {
+		resp, err = http.Get(srv.URL + "/tree" + query)
+		is.NoErr(err)
+		resp.Body.Close()
+		is.Equal(resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestArchiveHandler_Aborted(t *testing.T) {
+	var (
+		is   = is.New(t)
+		fsys = &failingFS{extractTestFS(), "tree/many/25.txt"}
+		srv  = httptest.NewServer(ArchiveHandler(fsys, nil))
+	)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/tree")
+	is.NoErr(err)
+	err = ExtractResponse(context.Background(), t.TempDir(), resp, nil)
+	is.True(err != nil) // a failed archive must not look complete
+}
@@ -0,0 +1,112 @@
+package pitch
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy controls how symbolic links found while walking a tree are archived.
+type SymlinkPolicy uint8
+
+const (
+	// SymlinkFollow archives whatever a link points to, descending into linked directories.
+	// Links that cannot be followed, because they dangle, loop or lead outside of the
+	// file system, fail the archive.
+	SymlinkFollow SymlinkPolicy = iota
+	// SymlinkStore archives the link itself as an empty entry of type EntryTypeSymlink.
+	SymlinkStore
+	// SymlinkSkip leaves links out of the archive.
+	SymlinkSkip
+	// SymlinkFollowWithinRoot follows links that resolve inside the archived root
+	// and stores the others as links.
+	SymlinkFollowWithinRoot
+	// SymlinkFollowOrStore is like SymlinkFollow but stores links that cannot be followed as links.
+	SymlinkFollowOrStore
+)
+
+// maxSymlinkHops bounds how many links are resolved for a single path, matching the usual ELOOP limit.
+const maxSymlinkHops = 40
+
+var (
+	errSymlinkLoop = errors.New("pitch: too many levels of symbolic links")
+	errOutsideFS   = errors.New("pitch: symlink target outside of file system")
+)
+
+// resolvePath resolves every symbolic link in name and returns the path in fsys it refers to.
+// Absolute link targets are only understood when fsys is rooted at the OS directory osDir,
+// in which case targets within osDir are resolved within fsys.
+func resolvePath(fsys fs.FS, name, osDir string) (string, error) {
+	var (
+		resolved = "."
+		rest     = strings.Split(name, "/")
+		hops     int
+	)
+
+	for 0 < len(rest) {
+		elem := rest[0]
+		rest = rest[1:]
+
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if resolved == "." {
+				return "", errOutsideFS
+			}
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, elem)
+		info, err := fs.Lstat(fsys, next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if hops++; maxSymlinkHops < hops {
+			return "", errSymlinkLoop
+		}
+
+		target, err := fs.ReadLink(fsys, next)
+		if err != nil {
+			return "", err
+		}
+		if osDir != "" {
+			target = filepath.ToSlash(target)
+		}
+		if path.IsAbs(target) || filepath.IsAbs(filepath.FromSlash(target)) {
+			rel, ok := osRel(osDir, target)
+			if !ok {
+				return "", errOutsideFS
+			}
+			resolved, target = ".", rel
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+
+	return resolved, nil
+}
+
+// osRel returns the absolute OS path target relative to the OS directory dir, if it lies within it.
+func osRel(dir, target string) (string, bool) {
+	if dir == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(dir, filepath.FromSlash(target))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// isWithin reports whether name is dir or lies beneath it.
+func isWithin(name, dir string) bool {
+	return dir == "." || name == dir || strings.HasPrefix(name, dir+"/")
+}
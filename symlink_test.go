@@ -0,0 +1,238 @@
+package pitch
+
+import (
+	"bytes"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+func TestArchiveFS_Symlinks(t *testing.T) {
+	var (
+		is = is.New(t)
+
+		link = func(target string) *fstest.MapFile {
+			return &fstest.MapFile{
+				Data: []byte(target),
+				Mode: fs.ModeSymlink,
+			}
+		}
+
+		fsys = fstest.MapFS{
+			"root/a.txt":    {Data: []byte("AAA")},
+			"root/rel":      link("a.txt"),
+			"root/sub/up":   link("../a.txt"),
+			"root/sub/back": link(".."),
+			"root/dirlink":  link("sub"),
+			"root/loop":     link("loop"),
+			"root/out":      link("../outside.txt"),
+			"root/dangling": link("nope"),
+			"outside.txt":   {Data: []byte("OUT")},
+		}
+
+		// links is the set of entries stored as links for a policy, mapped to their targets
+		tests = []struct {
+			name     string
+			policy   SymlinkPolicy
+			contents map[string]string
+			links    map[string]string
+		}{
+			{
+				name:   "follow_or_store",
+				policy: SymlinkFollowOrStore,
+				contents: map[string]string{
+					"root/a.txt":      "AAA",
+					"root/rel":        "AAA",
+					"root/sub/up":     "AAA",
+					"root/dirlink/up": "AAA",
+					"root/out":        "OUT",
+				},
+				links: map[string]string{
+					"root/sub/back":     "..",
+					"root/dirlink/back": "..",
+					"root/loop":         "loop",
+					"root/dangling":     "nope",
+				},
+			},
+			{
+				name:   "follow_within_root",
+				policy: SymlinkFollowWithinRoot,
+				contents: map[string]string{
+					"root/a.txt":      "AAA",
+					"root/rel":        "AAA",
+					"root/sub/up":     "AAA",
+					"root/dirlink/up": "AAA",
+				},
+				links: map[string]string{
+					"root/sub/back":     "..",
+					"root/dirlink/back": "..",
+					"root/loop":         "loop",
+					"root/dangling":     "nope",
+					"root/out":          "../outside.txt",
+				},
+			},
+			{
+				name:   "store",
+				policy: SymlinkStore,
+				contents: map[string]string{
+					"root/a.txt": "AAA",
+				},
+				links: map[string]string{
+					"root/rel":      "a.txt",
+					"root/sub/up":   "../a.txt",
+					"root/sub/back": "..",
+					"root/dirlink":  "sub",
+					"root/loop":     "loop",
+					"root/out":      "../outside.txt",
+					"root/dangling": "nope",
+				},
+			},
+			{
+				name:   "skip",
+				policy: SymlinkSkip,
+				contents: map[string]string{
+					"root/a.txt": "AAA",
+				},
+				links: map[string]string{},
+			},
+		}
+	)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				is  = is.New(t)
+				buf = bytes.NewBuffer(nil)
+			)
+
+			err := ArchiveFS(&nopCloser{buf}, fsys, "root", &ArchiveOptions{
+				Symlinks: test.policy,
+			})
+			is.NoErr(err)
+
+			contents, headers, err := readArchive(NewReader(buf))
+			is.NoErr(err)
+
+			var links = make(map[string]string)
+			for name, hdr := range headers {
+				if EntryType(hdr.Data) != EntryTypeSymlink {
+					continue
+				}
+				is.Equal(hdr.Size, uint64(0))
+				links[name] = hdr.Data[DataKeyLinkTarget][0]
+				delete(contents, name)
+			}
+
+			is.Equal(contents, test.contents)
+			is.Equal(links, test.links)
+		})
+	}
+}
+
+func TestArchiveFS_SymlinkFollow(t *testing.T) {
+	var (
+		link = func(target string) *fstest.MapFile {
+			return &fstest.MapFile{
+				Data: []byte(target),
+				Mode: fs.ModeSymlink,
+			}
+		}
+		tests = map[string]*fstest.MapFile{
+			"loop":     link("loop"),
+			"dangling": link("nope"),
+			"outside":  link("../outside.txt"),
+			"parent":   link(".."),
+		}
+	)
+
+	for name, l := range tests {
+		t.Run(name, func(t *testing.T) {
+			var is = is.New(t)
+
+			fsys := fstest.MapFS{
+				"root/a.txt":   {Data: []byte("AAA")},
+				"root/sub/ok":  link("../a.txt"),
+				"root/sub/bad": l,
+				"outside.txt":  {Data: []byte("OUT")},
+			}
+			err := ArchiveFS(&nopCloser{bytes.NewBuffer(nil)}, fsys, "root", nil)
+			is.True(err != nil) // links that cannot be followed fail the archive
+		})
+	}
+}
+
+func TestArchiveDir_RelativeSymlinks(t *testing.T) {
+	var (
+		is  = is.New(t)
+		buf = bytes.NewBuffer(nil)
+
+		tempDir     = t.TempDir()
+		tempDirName = filepath.Base(tempDir)
+	)
+
+	err := createTestDir(tempDir, map[string][]byte{
+		"a.txt": []byte("AAA"),
+	}, map[string]string{
+		"foo/a.txt": "../a.txt",
+		"foo/self":  ".",
+	})
+	is.NoErr(err)
+
+	// run from elsewhere so relative targets cannot accidentally resolve against the CWD
+	t.Chdir(t.TempDir())
+
+	err = ArchiveDir(&nopCloser{buf}, tempDir)
+	is.True(err != nil) // foo/self loops
+
+	buf.Reset()
+	err = ArchiveDirWithOptions(&nopCloser{buf}, tempDir, &ArchiveOptions{Symlinks: SymlinkFollowOrStore})
+	is.NoErr(err)
+
+	contents, headers, err := readArchive(NewReader(buf))
+	is.NoErr(err)
+
+	is.Equal(contents[tempDirName+"/foo/a.txt"], "AAA")
+	is.Equal(EntryType(headers[tempDirName+"/foo/self"].Data), EntryTypeSymlink)
+}
+
+func TestArchiveDir_AbsoluteSymlinks(t *testing.T) {
+	var (
+		is  = is.New(t)
+		buf = bytes.NewBuffer(nil)
+
+		outside = t.TempDir()
+		tempDir = filepath.Join(t.TempDir(), "root")
+	)
+
+	err := createTestDir(outside, map[string][]byte{
+		"out.txt":    []byte("OUT"),
+		"dir/in.txt": []byte("IN"),
+	}, nil)
+	is.NoErr(err)
+	err = createTestDir(tempDir, map[string][]byte{
+		"a.txt": []byte("AAA"),
+	}, map[string]string{
+		"abs":    filepath.Join(tempDir, "a.txt"),
+		"out":    filepath.Join(outside, "out.txt"),
+		"outdir": filepath.Join(outside, "dir"),
+	})
+	is.NoErr(err)
+
+	err = ArchiveDir(&nopCloser{buf}, tempDir)
+	is.True(err != nil) // outdir cannot be followed
+
+	buf.Reset()
+	err = ArchiveDirWithOptions(&nopCloser{buf}, tempDir, &ArchiveOptions{Symlinks: SymlinkFollowOrStore})
+	is.NoErr(err)
+
+	contents, headers, err := readArchive(NewReader(buf))
+	is.NoErr(err)
+
+	is.Equal(contents["root/abs"], "AAA")
+	is.Equal(contents["root/out"], "OUT")
+	// directories outside of the parent of the archived directory are not walked
+	is.Equal(EntryType(headers["root/outdir"].Data), EntryTypeSymlink)
+}
@@ -0,0 +1,294 @@
+package pitch
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paxDataPrefix prefixes the PAX records ToTar stores user-defined header data in.
+const paxDataPrefix = "PITCH.data."
+
+// FromTar copies every entry of the tar archive read from r into w, which is not closed.
+//
+// Modes, owners, modification times and links are stored in the header data of each entry,
+// see DataKeyMode and friends. Vendor specific PAX records, such as extended attributes,
+// are kept under DataKeyPAXPrefix. A global header in front of every entry becomes the
+// archive data of w. Hard links become EntryTypeHardlink entries, which Extract restores.
+// Tar entries without a pitch representation, such as devices and FIFOs, are skipped.
+func FromTar(r io.Reader, w *Writer) error {
+	return FromTarContext(context.Background(), r, w)
+}
+
+// FromTarContext is like FromTar but gives up once ctx is done.
+func FromTarContext(ctx context.Context, r io.Reader, w *Writer) error {
+	tr := tar.NewReader(&contextReader{ctx: ctx, r: r})
+	for {
+		th, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar header: %w", err)
+		}
+
+		// a global header in front of every entry holds the archive data
+		if th.Typeflag == tar.TypeXGlobalHeader && w.Offset() == 0 {
+			data := make(map[string][]string)
+			if err := dataFromPAX(data, th.PAXRecords); err != nil {
+				return fmt.Errorf("error converting tar global header: %w", err)
+			}
+			if err := w.SetArchiveData(data); err != nil {
+				return fmt.Errorf("error writing archive data: %w", err)
+			}
+			continue
+		}
+
+		hdr, err := headerFromTar(th)
+		if err != nil {
+			return fmt.Errorf("error converting tar header [%s]: %w", th.Name, err)
+		}
+		if hdr == nil {
+			continue
+		}
+
+		if _, err := w.WriteHeader(hdr.Name, int64(hdr.Size), hdr.Data); err != nil {
+			return fmt.Errorf("error writing header (%s, %d): %w", hdr.Name, hdr.Size, err)
+		}
+		if hdr.Size == 0 {
+			continue
+		}
+		if _, err := io.Copy(w, tr); err != nil {
+			return fmt.Errorf("error copying file [%s]: %w", hdr.Name, err)
+		}
+	}
+}
+
+// ToTar copies every entry read from r into a tar archive written to w.
+// It is the inverse of FromTar; user-defined header data is kept in PAX records,
+// and archive data in a global header.
+// The tar archive is finished, but w is not closed.
+func ToTar(r Reader, w io.Writer) error {
+	return ToTarContext(context.Background(), r, w)
+}
+
+// ToTarContext is like ToTar but gives up once ctx is done.
+func ToTarContext(ctx context.Context, r Reader, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	if data := ArchiveData(r); 0 < len(data) {
+		th := tar.Header{
+			Typeflag: tar.TypeXGlobalHeader,
+			Name:     "pax_global_header",
+		}
+		for k, v := range data {
+			if err := setPAXData(&th, k, v); err != nil {
+				return fmt.Errorf("error converting archive data: %w", err)
+			}
+		}
+		if err := tw.WriteHeader(&th); err != nil {
+			return fmt.Errorf("error writing tar global header: %w", err)
+		}
+	}
+
+	for {
+		hdr, err := NextContext(ctx, r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading header: %w", err)
+		}
+
+		th, err := tarHeader(hdr)
+		if err != nil {
+			return fmt.Errorf("error converting header [%s]: %w", hdr.Name, err)
+		}
+
+		if err := tw.WriteHeader(th); err != nil {
+			return fmt.Errorf("error writing tar header [%s]: %w", hdr.Name, err)
+		}
+		if th.Size == 0 {
+			continue
+		}
+		if _, err := io.Copy(tw, &contextReader{ctx: ctx, r: r}); err != nil {
+			return fmt.Errorf("error copying file [%s]: %w", hdr.Name, err)
+		}
+	}
+
+	return tw.Close()
+}
+
+// headerFromTar returns the pitch header for th, or nil if th cannot be represented.
+func headerFromTar(th *tar.Header) (*Header, error) {
+	var (
+		hdr = Header{
+			Name: entryName(th.Name),
+		}
+		data = map[string][]string{
+			DataKeyMode:    {FormatMode(th.FileInfo().Mode())},
+			DataKeyModTime: {FormatModTime(th.ModTime)},
+			DataKeyUID:     {strconv.Itoa(th.Uid)},
+			DataKeyGID:     {strconv.Itoa(th.Gid)},
+		}
+	)
+
+	switch th.Typeflag {
+	case tar.TypeReg, tar.TypeGNUSparse:
+		hdr.Size = uint64(th.Size)
+	case tar.TypeSymlink:
+		data[DataKeyType] = []string{EntryTypeSymlink}
+		data[DataKeyLinkTarget] = []string{th.Linkname}
+	case tar.TypeLink:
+		data[DataKeyType] = []string{EntryTypeHardlink}
+		data[DataKeyLinkTarget] = []string{entryName(th.Linkname)}
+	case tar.TypeDir:
+		if hdr.Name == "." || hdr.Name == "" {
+			return nil, nil
+		}
+		data[DataKeyType] = []string{EntryTypeDir}
+	default:
+		return nil, nil
+	}
+
+	if th.Uname != "" {
+		data[DataKeyUname] = []string{th.Uname}
+	}
+	if th.Gname != "" {
+		data[DataKeyGname] = []string{th.Gname}
+	}
+
+	if err := dataFromPAX(data, th.PAXRecords); err != nil {
+		return nil, err
+	}
+
+	hdr.Data = data
+	return &hdr, nil
+}
+
+// dataFromPAX adds the header data kept in the PAX records records to data.
+func dataFromPAX(data map[string][]string, records map[string]string) error {
+	for k, v := range records {
+		switch {
+		case strings.HasPrefix(k, paxDataPrefix):
+			key := strings.TrimPrefix(k, paxDataPrefix)
+			if isStorageKey(key) {
+				// the tar entry holds the whole file, however it was stored in the pitch archive
+				continue
+			}
+			var values []string
+			if err := json.Unmarshal([]byte(v), &values); err != nil {
+				return fmt.Errorf("invalid PAX record %s: %w", k, err)
+			}
+			data[key] = values
+		case strings.HasPrefix(k, "GNU.sparse."):
+			// handled by archive/tar
+		case strings.Contains(k, "."):
+			data[DataKeyPAXPrefix+k] = []string{v}
+		}
+	}
+	return nil
+}
+
+// tarHeader returns the tar header for hdr.
+func tarHeader(hdr *Header) (*tar.Header, error) {
+	th := tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     hdr.Name,
+		Size:     int64(hdr.Size),
+		Mode:     0o644,
+		ModTime:  time.Unix(0, 0),
+	}
+
+	switch typ := EntryType(hdr.Data); typ {
+	case "":
+	case EntryTypeSymlink, EntryTypeHardlink:
+		th.Typeflag = tar.TypeSymlink
+		if typ == EntryTypeHardlink {
+			th.Typeflag = tar.TypeLink
+		}
+		th.Size = 0
+		th.Mode = 0o777
+		if v := hdr.Data[DataKeyLinkTarget]; 0 < len(v) {
+			th.Linkname = v[0]
+		}
+	case EntryTypeDir:
+		th.Typeflag = tar.TypeDir
+		th.Name += "/"
+		th.Size = 0
+		th.Mode = 0o755
+	default:
+		return nil, fmt.Errorf("unsupported entry type %q", typ)
+	}
+
+	for k, v := range hdr.Data {
+		// the tar entry holds the whole file, however it was stored in the pitch archive
+		if len(v) == 0 || isStorageKey(k) {
+			continue
+		}
+
+		var err error
+		switch k {
+		case DataKeyType, DataKeyLinkTarget:
+		case DataKeyMode:
+			th.Mode, err = strconv.ParseInt(v[0], 8, 64)
+		case DataKeyModTime:
+			th.ModTime, err = ParseModTime(v[0])
+		case DataKeyUID:
+			th.Uid, err = strconv.Atoi(v[0])
+		case DataKeyGID:
+			th.Gid, err = strconv.Atoi(v[0])
+		case DataKeyUname:
+			th.Uname = v[0]
+		case DataKeyGname:
+			th.Gname = v[0]
+		default:
+			err = setPAXData(&th, k, v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", k, err)
+		}
+	}
+
+	return &th, nil
+}
+
+// setPAXData stores the header data value v under k in the PAX records of th.
+func setPAXData(th *tar.Header, k string, v []string) error {
+	if strings.HasPrefix(k, DataKeyPAXPrefix) {
+		if 0 < len(v) {
+			setPAXRecord(th, strings.TrimPrefix(k, DataKeyPAXPrefix), v[0])
+		}
+		return nil
+	}
+	values, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	setPAXRecord(th, paxDataPrefix+k, string(values))
+	return nil
+}
+
+// entryName returns the entry name for a name found in another archive format.
+// Leading slashes are dropped, like tar does.
+func entryName(name string) string {
+	return strings.TrimLeft(path.Clean(name), "/")
+}
+
+func setPAXRecord(th *tar.Header, k, v string) {
+	// keys with '=' cannot be represented
+	if strings.Contains(k, "=") {
+		return
+	}
+	if th.PAXRecords == nil {
+		th.PAXRecords = make(map[string]string)
+	}
+	th.PAXRecords[k] = v
+	th.Format = tar.FormatPAX
+}
@@ -0,0 +1,199 @@
+package pitch
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestTar_RoundTrip(t *testing.T) {
+	var (
+		is      = is.New(t)
+		modTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		tarBuf  = bytes.NewBuffer(nil)
+		tw      = tar.NewWriter(tarBuf)
+	)
+
+	headers := []*tar.Header{
+		{Typeflag: tar.TypeDir, Name: "tree/", Mode: 0o750, ModTime: modTime},
+		{Typeflag: tar.TypeReg, Name: "tree/a.txt", Size: 3, Mode: 0o640, ModTime: modTime, Uid: 1000, Gid: 100, Uname: "alice", Gname: "users",
+			PAXRecords: map[string]string{"SCHILY.xattr.user.note": "hi"}},
+		{Typeflag: tar.TypeSymlink, Name: "tree/link", Linkname: "a.txt", Mode: 0o777, ModTime: modTime},
+		{Typeflag: tar.TypeLink, Name: "tree/hard", Linkname: "tree/a.txt", Mode: 0o640, ModTime: modTime},
+		{Typeflag: tar.TypeFifo, Name: "tree/fifo", Mode: 0o644, ModTime: modTime},
+	}
+	for _, th := range headers {
+		is.NoErr(tw.WriteHeader(th))
+		if th.Size != 0 {
+			_, err := tw.Write([]byte("AAA"))
+			is.NoErr(err)
+		}
+	}
+	is.NoErr(tw.Close())
+
+	pchBuf := bytes.NewBuffer(nil)
+	pw := NewWriter(pchBuf)
+	is.NoErr(FromTar(tarBuf, pw))
+	is.NoErr(pw.Close())
+
+	contents, hdrs, err := readArchive(NewReader(bytes.NewReader(pchBuf.Bytes())))
+	is.NoErr(err)
+	is.Equal(len(hdrs), 4) // the fifo is skipped
+	is.Equal(contents["tree/a.txt"], "AAA")
+	is.Equal(EntryType(hdrs["tree"].Data), EntryTypeDir)
+	is.Equal(hdrs["tree"].Data[DataKeyMode], []string{"0750"})
+	is.Equal(hdrs["tree/a.txt"].Data[DataKeyMode], []string{"0640"})
+	is.Equal(hdrs["tree/a.txt"].Data[DataKeyModTime], []string{FormatModTime(modTime)})
+	is.Equal(hdrs["tree/a.txt"].Data[DataKeyUname], []string{"alice"})
+	is.Equal(hdrs["tree/a.txt"].Data[DataKeyPAXPrefix+"SCHILY.xattr.user.note"], []string{"hi"})
+	is.Equal(EntryType(hdrs["tree/link"].Data), EntryTypeSymlink)
+	is.Equal(hdrs["tree/link"].Data[DataKeyLinkTarget], []string{"a.txt"})
+	is.Equal(EntryType(hdrs["tree/hard"].Data), EntryTypeHardlink)
+	is.Equal(hdrs["tree/hard"].Data[DataKeyLinkTarget], []string{"tree/a.txt"})
+
+	tarBuf.Reset()
+	is.NoErr(ToTar(NewReader(bytes.NewReader(pchBuf.Bytes())), tarBuf))
+
+	tr := tar.NewReader(tarBuf)
+	for _, expected := range headers[:4] {
+		th, err := tr.Next()
+		is.NoErr(err)
+		is.Equal(th.Typeflag, expected.Typeflag)
+		is.Equal(th.Name, expected.Name)
+		is.Equal(th.Linkname, expected.Linkname)
+		is.Equal(th.Size, expected.Size)
+		is.Equal(th.Mode, expected.Mode)
+		is.True(th.ModTime.Equal(expected.ModTime))
+		is.Equal(th.Uid, expected.Uid)
+		is.Equal(th.Uname, expected.Uname)
+		is.Equal(th.PAXRecords["SCHILY.xattr.user.note"], expected.PAXRecords["SCHILY.xattr.user.note"])
+	}
+	_, err = tr.Next()
+	is.True(errors.Is(err, io.EOF))
+}
+
+func TestTar_StorageKeys(t *testing.T) {
+	var (
+		is     = is.New(t)
+		pchBuf = bytes.NewBuffer(nil)
+		pw     = NewWriter(pchBuf)
+	)
+
+	// 12 logical bytes with "ABC" at 2 and "DE" at 8
+	writeTestEntries(t, pw, testEntry{name: "sparse", content: "ABCDE", data: map[string][]string{
+		DataKeySparseMap:  {"2:3", "8:2"},
+		DataKeySparseSize: {"12"},
+	}})
+	offset := pw.Offset()
+	writeTestEntries(t, pw, testEntry{name: "original", content: "hello"})
+	_, err := pw.WriteReference("copy", offset+int64(EncodedHeaderSize("original", 5, nil)), 5, nil)
+	is.NoErr(err)
+	is.NoErr(pw.Close())
+
+	tarBuf := bytes.NewBuffer(nil)
+	is.NoErr(ToTar(NewReader(bytes.NewReader(pchBuf.Bytes())), tarBuf))
+
+	pchBuf.Reset()
+	pw = NewWriter(pchBuf)
+	is.NoErr(FromTar(tarBuf, pw))
+
+	// storage keys written by other tools are ignored too
+	tarBuf.Reset()
+	tw := tar.NewWriter(tarBuf)
+	is.NoErr(tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     "foreign",
+		Size:     3,
+		Mode:     0o644,
+		PAXRecords: map[string]string{
+			paxDataPrefix + DataKeySparseMap:  `["0:1"]`,
+			paxDataPrefix + DataKeySparseSize: `["9"]`,
+		},
+	}))
+	_, err = tw.Write([]byte("FFF"))
+	is.NoErr(err)
+	is.NoErr(tw.Close())
+	is.NoErr(FromTar(tarBuf, pw))
+	is.NoErr(pw.Close())
+
+	contents, hdrs, err := readArchive(NewReader(bytes.NewReader(pchBuf.Bytes())))
+	is.NoErr(err)
+	is.Equal(contents["sparse"], "\x00\x00ABC\x00\x00\x00DE\x00\x00")
+	is.Equal(contents["original"], "hello")
+	is.Equal(contents["copy"], "hello")
+	is.Equal(contents["foreign"], "FFF")
+	for _, hdr := range hdrs {
+		is.Equal(EntryType(hdr.Data), "")
+		for k := range hdr.Data {
+			is.True(!isStorageKey(k))
+		}
+	}
+}
+
+func TestTar_UserData(t *testing.T) {
+	var (
+		is     = is.New(t)
+		pchBuf = bytes.NewBuffer(nil)
+		pw     = NewWriter(pchBuf)
+		data   = map[string][]string{"owner": {"bob", "carol"}, "empty": {""}}
+	)
+
+	_, err := pw.WriteHeader("a.txt", 3, data)
+	is.NoErr(err)
+	_, err = pw.Write([]byte("AAA"))
+	is.NoErr(err)
+	is.NoErr(pw.Close())
+
+	tarBuf := bytes.NewBuffer(nil)
+	is.NoErr(ToTar(NewReader(pchBuf), tarBuf))
+
+	pchBuf.Reset()
+	pw = NewWriter(pchBuf)
+	is.NoErr(FromTar(tarBuf, pw))
+	is.NoErr(pw.Close())
+
+	contents, hdrs, err := readArchive(NewReader(pchBuf))
+	is.NoErr(err)
+	is.Equal(contents["a.txt"], "AAA")
+	is.Equal(hdrs["a.txt"].Data["owner"], data["owner"])
+	is.Equal(hdrs["a.txt"].Data["empty"], data["empty"])
+}
+
+func TestTar_ExtractHardlinks(t *testing.T) {
+	var (
+		is     = is.New(t)
+		tarBuf = bytes.NewBuffer(nil)
+		tw     = tar.NewWriter(tarBuf)
+		dir    = t.TempDir()
+	)
+
+	is.NoErr(tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "tree/a.txt", Size: 3, Mode: 0o644}))
+	_, err := tw.Write([]byte("AAA"))
+	is.NoErr(err)
+	is.NoErr(tw.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "tree/hard", Linkname: "tree/a.txt", Mode: 0o644}))
+	is.NoErr(tw.Close())
+
+	pchBuf := bytes.NewBuffer(nil)
+	pw := NewWriter(pchBuf)
+	is.NoErr(FromTar(tarBuf, pw))
+	is.NoErr(pw.Close())
+
+	is.NoErr(Extract(dir, NewReader(pchBuf), nil))
+
+	content, err := os.ReadFile(filepath.Join(dir, "tree", "hard"))
+	is.NoErr(err)
+	is.Equal(string(content), "AAA")
+
+	a, err := os.Stat(filepath.Join(dir, "tree", "a.txt"))
+	is.NoErr(err)
+	hard, err := os.Stat(filepath.Join(dir, "tree", "hard"))
+	is.NoErr(err)
+	is.True(os.SameFile(a, hard))
+}
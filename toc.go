@@ -0,0 +1,110 @@
+package pitch
+
+import (
+	"cmp"
+	"iter"
+	"path"
+	"slices"
+	"strings"
+)
+
+// All returns an iterator over the names and items of every entry of toc, in no particular order.
+func (toc TableOfContents) All() iter.Seq2[string, *HeaderItem] {
+	return func(yield func(string, *HeaderItem) bool) {
+		for name, item := range toc {
+			if !yield(name, item) {
+				return
+			}
+		}
+	}
+}
+
+// ByName returns an iterator over the names and items of every entry of toc, sorted by name.
+// Like every ordered iterator of TableOfContents, which is a map, it collects and sorts
+// the names it yields each time it is ranged over, in O(n log n) time and O(n) memory.
+// Use the All method of a CompactTableOfContents, which keeps its names sorted, to walk
+// a large table in order many times.
+func (toc TableOfContents) ByName() iter.Seq2[string, *HeaderItem] {
+	return toc.WithPrefix("")
+}
+
+// ByLocation returns an iterator over the names and items of every entry of toc, in archive order.
+// Reading contents in this order keeps access to the archive mostly sequential.
+// The items are collected and sorted each time it is ranged over, see ByName.
+func (toc TableOfContents) ByLocation() iter.Seq2[string, *HeaderItem] {
+	return func(yield func(string, *HeaderItem) bool) {
+		items := make([]*HeaderItem, 0, len(toc))
+		for _, item := range toc.All() {
+			items = append(items, item)
+		}
+		// references share the content of their targets
+		slices.SortFunc(items, func(x, y *HeaderItem) int {
+			return cmp.Or(cmp.Compare(x.Start, y.Start), strings.Compare(x.Name, y.Name))
+		})
+
+		for _, item := range items {
+			if !yield(item.Name, item) {
+				return
+			}
+		}
+	}
+}
+
+// WithPrefix returns an iterator over the names and items of the entries of toc
+// whose names start with prefix, sorted by name.
+// Every name is checked and the matching ones sorted each time it is ranged over, see ByName.
+func (toc TableOfContents) WithPrefix(prefix string) iter.Seq2[string, *HeaderItem] {
+	return func(yield func(string, *HeaderItem) bool) {
+		var names []string
+		for name := range toc.All() {
+			if strings.HasPrefix(name, prefix) {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+
+		for _, name := range names {
+			if !yield(name, toc[name]) {
+				return
+			}
+		}
+	}
+}
+
+// Dir returns an iterator over the names and items of the immediate children of directory dir, sorted by name.
+// The root directory is "." or "". Directories that only show in the names of deeper entries
+// are yielded with a nil item, like FS lists them.
+// Every name is checked and the children sorted each time it is ranged over, see ByName.
+func (toc TableOfContents) Dir(dir string) iter.Seq2[string, *HeaderItem] {
+	var prefix string
+	if dir = path.Clean(dir); dir != "." {
+		prefix = dir + "/"
+	}
+
+	return func(yield func(string, *HeaderItem) bool) {
+		children := make(map[string]*HeaderItem)
+		for name, item := range toc.All() {
+			rest, ok := strings.CutPrefix(name, prefix)
+			if !ok || rest == "" {
+				continue
+			}
+			if i := strings.IndexByte(rest, '/'); i != -1 {
+				name = prefix + rest[:i]
+				item = toc[name]
+			}
+			children[name] = item
+		}
+
+		names := make([]string, 0, len(children))
+		for name := range children {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		for _, name := range names {
+			if !yield(name, children[name]) {
+				return
+			}
+		}
+	}
+}
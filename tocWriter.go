@@ -12,11 +12,14 @@ type ByteRange struct {
 
 // HeaderItem is a struct that contains information about a file.
 // In each archive file, a file's header is followed by its content.
+//
+// HeaderItems encode to JSON and YAML losslessly, see MarshalJSON.
 type HeaderItem struct {
 	Name string `json:"name" yaml:"name"`
 	// Size is the size of the file content in bytes.
 	Size uint64 `json:"size" yaml:"size"`
 	// Data is a user-defined map of key-value pairs.
+	// Keys and values may hold arbitrary bytes, see DataBytes.
 	Data map[string][]string `json:"data,omitempty" yaml:"data,omitempty"`
 	// Start is the byte offset of the file content.
 	Start int64 `json:"start" yaml:"start"`
@@ -24,6 +27,24 @@ type HeaderItem struct {
 	End int64 `json:"end" yaml:"end"`
 }
 
+// Content returns a reader over the content of the item within the archive read by ra.
+// The holes of sparse files read as zeros.
+func (hi *HeaderItem) Content(ra io.ReaderAt) *io.SectionReader {
+	if segs, size, ok, err := expandSparse(hi.Data, uint64(hi.End-hi.Start)); err == nil && ok {
+		return io.NewSectionReader(newSparseReaderAt(ra, hi.Start, segs, size), 0, size)
+	}
+	return io.NewSectionReader(ra, hi.Start, hi.End-hi.Start)
+}
+
+// Header returns the header the item was built from.
+func (hi *HeaderItem) Header() *Header {
+	return &Header{
+		Name: hi.Name,
+		Size: hi.Size,
+		Data: hi.Data,
+	}
+}
+
 // TableOfContents is a map of file names to HeaderItems.
 type TableOfContents map[string]*HeaderItem
 
@@ -74,6 +95,7 @@ type TOCWriter struct {
 	w             io.Writer
 	toc           TableOfContents
 	offset        int64
+	ob            observed
 }
 
 func NewTOCWriter(w io.Writer) *TOCWriter {
@@ -83,6 +105,12 @@ func NewTOCWriter(w io.Writer) *TOCWriter {
 	}
 }
 
+// SetObserver makes the writer report every entry it writes to o.
+// An entry is done once all of its content has been written or padded.
+func (wtr *TOCWriter) SetObserver(o Observer) {
+	wtr.ob.o = o
+}
+
 func (wtr *TOCWriter) WriteHeader(name string, contentLength int64, data map[string][]string) error {
 	var w = wtr.w
 	if w == nil {
@@ -93,8 +121,10 @@ func (wtr *TOCWriter) WriteHeader(name string, contentLength int64, data map[str
 	}
 
 	if err := wtr.pad(); err != nil {
+		wtr.ob.done(err)
 		return fmt.Errorf("error padding file: %w", err)
 	}
+	wtr.ob.done(nil)
 
 	hdr := Header{
 		Name: name,
@@ -104,7 +134,9 @@ func (wtr *TOCWriter) WriteHeader(name string, contentLength int64, data map[str
 	payload := EncodeHeader(hdr)
 	wtr.offset += int64(len(payload))
 	_, err := w.Write(payload)
+	wtr.ob.start(&hdr)
 	if err != nil {
+		wtr.ob.done(err)
 		return err
 	}
 
@@ -142,13 +174,18 @@ func (wtr *TOCWriter) Write(b []byte) (int, error) {
 	}
 
 	m, err := w.Write(b[:n])
+	wtr.ob.progress(int64(m))
 	if err != nil {
+		wtr.ob.done(err)
 		return m, err
 	}
 
 	var m64 = int64(m)
 	wtr.offset += m64
 	wtr.contentLength -= m64
+	if wtr.contentLength == 0 {
+		wtr.ob.done(nil)
+	}
 
 	if isTooLong {
 		err = ErrWriteTooLong
@@ -163,8 +200,10 @@ func (wtr *TOCWriter) Close() error {
 	}
 
 	if err := wtr.pad(); err != nil {
+		wtr.ob.done(err)
 		return fmt.Errorf("error padding file: %w", err)
 	}
+	wtr.ob.done(nil)
 
 	wtr.w = nil
 
@@ -0,0 +1,73 @@
+package pitch
+
+import (
+	"iter"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func newIterTestTOC() TableOfContents {
+	toc := make(TableOfContents)
+	for i, name := range []string{"b.txt", "a/x.txt", "a/y/z.txt", "a/y", "c/d/e.txt", "ab.txt"} {
+		toc[name] = &HeaderItem{Name: name, Start: int64(100 - 10*i)}
+	}
+	return toc
+}
+
+func seqNames(seq iter.Seq2[string, *HeaderItem]) []string {
+	var names []string
+	for name, item := range seq {
+		if item != nil && item.Name != name {
+			panic("name mismatch")
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestTableOfContents_Iterators(t *testing.T) {
+	var (
+		is  = is.New(t)
+		toc = newIterTestTOC()
+	)
+
+	is.Equal(len(seqNames(toc.All())), 6)
+	is.Equal(seqNames(toc.ByName()), []string{"a/x.txt", "a/y", "a/y/z.txt", "ab.txt", "b.txt", "c/d/e.txt"})
+	is.Equal(seqNames(toc.ByLocation()), []string{"ab.txt", "c/d/e.txt", "a/y", "a/y/z.txt", "a/x.txt", "b.txt"})
+	is.Equal(seqNames(toc.WithPrefix("a/")), []string{"a/x.txt", "a/y", "a/y/z.txt"})
+	is.Equal(seqNames(toc.WithPrefix("zz")), []string(nil))
+
+	// stopping early
+	var n int
+	for range toc.ByLocation() {
+		n++
+		break
+	}
+	is.Equal(n, 1)
+}
+
+func TestTableOfContents_Dir(t *testing.T) {
+	var (
+		is  = is.New(t)
+		toc = newIterTestTOC()
+	)
+
+	is.Equal(seqNames(toc.Dir(".")), []string{"a", "ab.txt", "b.txt", "c"})
+	is.Equal(seqNames(toc.Dir("")), []string{"a", "ab.txt", "b.txt", "c"})
+	is.Equal(seqNames(toc.Dir("a")), []string{"a/x.txt", "a/y"})
+	is.Equal(seqNames(toc.Dir("a/")), []string{"a/x.txt", "a/y"})
+	is.Equal(seqNames(toc.Dir("a/y")), []string{"a/y/z.txt"})
+	is.Equal(seqNames(toc.Dir("b.txt")), []string(nil))
+	is.Equal(seqNames(toc.Dir("missing")), []string(nil))
+
+	// implied directories have no item, stored ones do
+	for name, item := range toc.Dir("a") {
+		is.Equal(item, toc[name])
+	}
+	for name, item := range toc.Dir(".") {
+		if name == "a" || name == "c" {
+			is.Equal(item, nil)
+		}
+	}
+}
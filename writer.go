@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"strconv"
 )
 
 var (
@@ -15,6 +17,12 @@ var (
 type Writer struct {
 	contentLength int64
 	w             io.Writer
+	ob            observed
+	// offset is the number of bytes written to w.
+	offset int64
+	schema *Schema
+	// index collects the entries to index, if an index is enabled.
+	index *indexBuilder
 }
 
 func NewWriter(w io.Writer) *Writer {
@@ -23,12 +31,21 @@ func NewWriter(w io.Writer) *Writer {
 	}
 }
 
+// SetObserver makes the writer report every entry it writes to o.
+// An entry is done once all of its content has been written.
+func (wtr *Writer) SetObserver(o Observer) {
+	wtr.ob.o = o
+}
+
 func (wtr *Writer) WriteHeader(name string, contentLength int64, data map[string][]string) (int, error) {
 	var (
 		w = wtr.w
 		n int
 	)
 
+	// the previous entry is still open if not all of its content was written
+	wtr.ob.done(io.ErrShortWrite)
+
 	if w == nil {
 		return 0, ErrClosed
 	}
@@ -37,19 +54,34 @@ func (wtr *Writer) WriteHeader(name string, contentLength int64, data map[string
 		return 0, ErrInvalidSize
 	}
 
+	if wtr.schema != nil {
+		if err := wtr.schema.Validate(data); err != nil {
+			return 0, fmt.Errorf("error writing %s: %w", name, err)
+		}
+	}
+
 	h := Header{
 		Name: name,
 		Size: uint64(contentLength),
 		Data: data,
 	}
+	if wtr.index != nil {
+		wtr.index.add(name, wtr.offset, data)
+	}
 	payload := EncodeHeader(h)
 	m, err := wtr.w.Write(payload)
 	n += m
+	wtr.offset += int64(m)
+	wtr.ob.start(&h)
 	if err != nil {
+		wtr.ob.done(err)
 		return n, err
 	}
 
 	wtr.contentLength = contentLength
+	if contentLength == 0 {
+		wtr.ob.done(nil)
+	}
 
 	return n, nil
 }
@@ -76,11 +108,17 @@ func (wtr *Writer) Write(b []byte) (int, error) {
 	}
 
 	m, err := w.Write(b[:n])
+	wtr.offset += int64(m)
+	wtr.ob.progress(int64(m))
 	if err != nil {
+		wtr.ob.done(err)
 		return m, err
 	}
 
 	wtr.contentLength -= int64(m)
+	if wtr.contentLength == 0 {
+		wtr.ob.done(nil)
+	}
 
 	if isTooLong {
 		err = fmt.Errorf("%w: %d", ErrWriteTooLong, n)
@@ -89,12 +127,53 @@ func (wtr *Writer) Write(b []byte) (int, error) {
 	return m, err
 }
 
+// Offset returns the number of bytes written so far,
+// which is the offset of the next header or content byte within the archive.
+func (wtr *Writer) Offset() int64 {
+	return wtr.offset
+}
+
+// WriteReference writes an entry whose content is the size bytes found at offset within the archive,
+// which must be content written earlier by wtr, e.g. at the Offset of wtr after an earlier WriteHeader.
+// No content is written for the entry; readers, tables of contents and extraction resolve it
+// to the earlier bytes. See EntryTypeRef.
+func (wtr *Writer) WriteReference(name string, offset, size int64, data map[string][]string) (int, error) {
+	if offset < 0 || size < 0 || wtr.offset < offset+size {
+		return 0, ErrInvalidSize
+	}
+
+	refData := make(map[string][]string, len(data)+3)
+	maps.Copy(refData, data)
+	refData[DataKeyType] = []string{EntryTypeRef}
+	refData[DataKeyRefOffset] = []string{strconv.FormatInt(offset, 10)}
+	refData[DataKeyRefSize] = []string{strconv.FormatInt(size, 10)}
+
+	return wtr.WriteHeader(name, 0, refData)
+}
+
+// Close finishes the archive; w itself is not closed.
+// If an index is enabled, the end of the archive is marked and the index is written after it.
 func (wtr *Writer) Close() error {
 	if wtr.w == nil {
 		return ErrClosed
 	}
 
+	wtr.ob.done(io.ErrShortWrite)
+	w := wtr.w
 	wtr.w = nil
 
+	if wtr.index == nil {
+		return nil
+	}
+	if wtr.contentLength != 0 {
+		return fmt.Errorf("error writing index: %w", io.ErrShortWrite)
+	}
+
+	// readers stop at the end marker, before the index
+	end := EncodeSize(NameSize, 0)
+	section := wtr.index.encode(wtr.offset + int64(len(end)))
+	if _, err := w.Write(append(end, section...)); err != nil {
+		return fmt.Errorf("error writing index: %w", err)
+	}
 	return nil
 }
@@ -3,11 +3,43 @@ package pitch
 import (
 	"bytes"
 	"errors"
+	"io"
 	"testing"
 
 	"github.com/matryer/is"
 )
 
+// testEntry is an entry written by writeTestArchive.
+type testEntry struct {
+	name, content string
+	data          map[string][]string
+}
+
+// writeTestArchive writes entries in order into a new archive and returns it.
+func writeTestArchive(t *testing.T, entries ...testEntry) []byte {
+	var (
+		is  = is.New(t)
+		buf = bytes.NewBuffer(nil)
+		w   = NewWriter(buf)
+	)
+	writeTestEntries(t, w, entries...)
+	is.NoErr(w.Close())
+	return buf.Bytes()
+}
+
+// writeTestEntries writes entries in order into w, for archives that need more than writeTestArchive.
+func writeTestEntries(t *testing.T, w *Writer, entries ...testEntry) {
+	is := is.New(t)
+	for _, e := range entries {
+		_, err := w.WriteHeader(e.name, int64(len(e.content)), e.data)
+		is.NoErr(err)
+		if e.content != "" {
+			_, err = io.WriteString(w, e.content)
+			is.NoErr(err)
+		}
+	}
+}
+
 func TestWriter_ErrClosed(t *testing.T) {
 	var (
 		is = is.New(t)
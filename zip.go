@@ -0,0 +1,215 @@
+package pitch
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// maxZipLinkTarget bounds the size of the link target stored as the content of a zip symlink.
+const maxZipLinkTarget = 4096
+
+// FromZip copies every entry of the zip archive of the given size read from r into w, which is not closed.
+// Entries are copied one at a time, nothing is extracted to disk.
+//
+// Modes, modification times and file comments are stored in the header data of each entry,
+// see DataKeyMode, DataKeyModTime and DataKeyComment; the archive comment is kept
+// under DataKeyComment in the archive data of w. Symlinks and directories are kept;
+// other entries without a pitch representation are skipped.
+func FromZip(r io.ReaderAt, size int64, w *Writer) error {
+	return FromZipContext(context.Background(), r, size, w)
+}
+
+// FromZipContext is like FromZip but gives up once ctx is done.
+func FromZipContext(ctx context.Context, r io.ReaderAt, size int64, w *Writer) error {
+	zr, err := zip.NewReader(r, size)
+	// insecure names are kept as they are, Extract refuses them
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		return fmt.Errorf("error reading zip archive: %w", err)
+	}
+
+	if zr.Comment != "" {
+		if err := w.SetArchiveData(map[string][]string{DataKeyComment: {zr.Comment}}); err != nil {
+			return fmt.Errorf("error writing archive data: %w", err)
+		}
+	}
+
+	for _, f := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := copyZipFile(ctx, f, w); err != nil {
+			return fmt.Errorf("error copying file [%s]: %w", f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func copyZipFile(ctx context.Context, f *zip.File, w *Writer) error {
+	var (
+		name = entryName(f.Name)
+		mode = f.Mode()
+		data = map[string][]string{
+			DataKeyMode: {FormatMode(mode)},
+		}
+		size int64
+	)
+	if !f.Modified.IsZero() {
+		data[DataKeyModTime] = []string{FormatModTime(f.Modified)}
+	}
+	if f.Comment != "" {
+		data[DataKeyComment] = []string{f.Comment}
+	}
+
+	switch {
+	case mode.IsDir():
+		if name == "." {
+			return nil
+		}
+		data[DataKeyType] = []string{EntryTypeDir}
+	case mode&fs.ModeSymlink != 0:
+		target, err := readZipLink(f)
+		if err != nil {
+			return err
+		}
+		data[DataKeyType] = []string{EntryTypeSymlink}
+		data[DataKeyLinkTarget] = []string{target}
+	case mode.IsRegular():
+		size = int64(f.UncompressedSize64)
+	default:
+		return nil
+	}
+
+	if _, err := w.WriteHeader(name, size, data); err != nil {
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(w, &contextReader{ctx: ctx, r: rc})
+	return err
+}
+
+func readZipLink(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	target, err := io.ReadAll(io.LimitReader(rc, maxZipLinkTarget))
+	if err != nil {
+		return "", err
+	}
+	return string(target), nil
+}
+
+// ToZip copies every entry of the pitch archive of the given size read from r into a zip archive written to w.
+// It is the inverse of FromZip. Hard links are stored as copies of their targets,
+// since zip has no way to represent them.
+// The zip archive is finished, but w is not closed.
+func ToZip(r io.ReaderAt, size int64, w io.Writer) error {
+	return ToZipContext(context.Background(), r, size, w)
+}
+
+// ToZipContext is like ToZip but gives up once ctx is done.
+func ToZipContext(ctx context.Context, r io.ReaderAt, size int64, w io.Writer) error {
+	pr := NewReader(io.NewSectionReader(r, 0, size))
+	toc, err := BuildTableOfContentsContext(ctx, pr)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	if v := ArchiveData(pr)[DataKeyComment]; 0 < len(v) {
+		if err := zw.SetComment(v[0]); err != nil {
+			return err
+		}
+	}
+	for _, item := range toc.ByLocation() {
+		if err := writeZipFile(ctx, zw, r, toc, item); err != nil {
+			return fmt.Errorf("error copying file [%s]: %w", item.Name, err)
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeZipFile(ctx context.Context, zw *zip.Writer, r io.ReaderAt, toc TableOfContents, item *HeaderItem) error {
+	var (
+		fh = zip.FileHeader{
+			Name:   item.Name,
+			Method: zip.Deflate,
+		}
+		mode    fs.FileMode = 0o644
+		content io.Reader   = item.Content(r)
+	)
+
+	if v := item.Data[DataKeyModTime]; 0 < len(v) {
+		modTime, err := ParseModTime(v[0])
+		if err != nil {
+			return err
+		}
+		fh.Modified = modTime
+	}
+	if v := item.Data[DataKeyComment]; 0 < len(v) {
+		fh.Comment = v[0]
+	}
+
+	var target string
+	if v := item.Data[DataKeyLinkTarget]; 0 < len(v) {
+		target = v[0]
+	}
+
+	switch typ := EntryType(item.Data); typ {
+	case "":
+	case EntryTypeSymlink:
+		mode = fs.ModeSymlink | 0o777
+		content = strings.NewReader(target)
+	case EntryTypeHardlink:
+		linked, ok := toc[target]
+		if !ok || EntryType(linked.Data) != "" {
+			return fmt.Errorf("hard link to missing file %q", target)
+		}
+		content = linked.Content(r)
+	case EntryTypeDir:
+		fh.Name += "/"
+		fh.Method = zip.Store
+		mode = fs.ModeDir | 0o755
+		content = nil
+	default:
+		return fmt.Errorf("unsupported entry type %q", typ)
+	}
+
+	if v := item.Data[DataKeyMode]; 0 < len(v) {
+		perm, err := ParseMode(v[0])
+		if err != nil {
+			return err
+		}
+		mode = mode.Type() | perm
+	}
+	fh.SetMode(mode)
+
+	fw, err := zw.CreateHeader(&fh)
+	if err != nil {
+		return err
+	}
+	if content == nil {
+		return nil
+	}
+
+	_, err = io.Copy(fw, &contextReader{ctx: ctx, r: content})
+	return err
+}
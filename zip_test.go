@@ -0,0 +1,112 @@
+package pitch
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestZip_RoundTrip(t *testing.T) {
+	var (
+		is      = is.New(t)
+		modTime = time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC)
+		zipBuf  = bytes.NewBuffer(nil)
+		zw      = zip.NewWriter(zipBuf)
+	)
+
+	files := []struct {
+		name, comment, content string
+		mode                   fs.FileMode
+	}{
+		{name: "tree/", mode: fs.ModeDir | 0o750},
+		{name: "tree/a.txt", comment: "the letter a", content: "AAA", mode: 0o640},
+		{name: "tree/link", content: "a.txt", mode: fs.ModeSymlink | 0o777},
+	}
+	for _, f := range files {
+		fh := zip.FileHeader{
+			Name:     f.name,
+			Comment:  f.comment,
+			Modified: modTime,
+			Method:   zip.Deflate,
+		}
+		fh.SetMode(f.mode)
+		fw, err := zw.CreateHeader(&fh)
+		is.NoErr(err)
+		_, err = io.WriteString(fw, f.content)
+		is.NoErr(err)
+	}
+	is.NoErr(zw.Close())
+
+	pchBuf := bytes.NewBuffer(nil)
+	pw := NewWriter(pchBuf)
+	is.NoErr(FromZip(bytes.NewReader(zipBuf.Bytes()), int64(zipBuf.Len()), pw))
+	is.NoErr(pw.Close())
+
+	contents, hdrs, err := readArchive(NewReader(bytes.NewReader(pchBuf.Bytes())))
+	is.NoErr(err)
+	is.Equal(len(hdrs), 3)
+	is.Equal(contents["tree/a.txt"], "AAA")
+	is.Equal(hdrs["tree/a.txt"].Size, uint64(3))
+	is.Equal(hdrs["tree/a.txt"].Data[DataKeyComment], []string{"the letter a"})
+	is.Equal(hdrs["tree/a.txt"].Data[DataKeyMode], []string{"0640"})
+	is.Equal(hdrs["tree/a.txt"].Data[DataKeyModTime], []string{FormatModTime(modTime)})
+	is.Equal(EntryType(hdrs["tree"].Data), EntryTypeDir)
+	is.Equal(EntryType(hdrs["tree/link"].Data), EntryTypeSymlink)
+	is.Equal(hdrs["tree/link"].Data[DataKeyLinkTarget], []string{"a.txt"})
+
+	zipBuf.Reset()
+	is.NoErr(ToZip(bytes.NewReader(pchBuf.Bytes()), int64(pchBuf.Len()), zipBuf))
+
+	zr, err := zip.NewReader(bytes.NewReader(zipBuf.Bytes()), int64(zipBuf.Len()))
+	is.NoErr(err)
+	is.Equal(len(zr.File), len(files))
+	for i, f := range zr.File {
+		expected := files[i]
+		is.Equal(f.Name, expected.name)
+		is.Equal(f.Comment, expected.comment)
+		is.Equal(f.Mode(), expected.mode)
+		is.True(f.Modified.Equal(modTime))
+
+		rc, err := f.Open()
+		is.NoErr(err)
+		content, err := io.ReadAll(rc)
+		is.NoErr(err)
+		is.NoErr(rc.Close())
+		is.Equal(string(content), expected.content)
+	}
+}
+
+func TestToZip_Hardlink(t *testing.T) {
+	var (
+		is     = is.New(t)
+		pchBuf = bytes.NewBuffer(nil)
+		pw     = NewWriter(pchBuf)
+	)
+
+	_, err := pw.WriteHeader("a.txt", 3, nil)
+	is.NoErr(err)
+	_, err = pw.Write([]byte("AAA"))
+	is.NoErr(err)
+	_, err = pw.WriteHeader("b.txt", 0, map[string][]string{
+		DataKeyType:       {EntryTypeHardlink},
+		DataKeyLinkTarget: {"a.txt"},
+	})
+	is.NoErr(err)
+	is.NoErr(pw.Close())
+
+	zipBuf := bytes.NewBuffer(nil)
+	is.NoErr(ToZip(bytes.NewReader(pchBuf.Bytes()), int64(pchBuf.Len()), zipBuf))
+
+	zr, err := zip.NewReader(bytes.NewReader(zipBuf.Bytes()), int64(zipBuf.Len()))
+	is.NoErr(err)
+	rc, err := zr.Open("b.txt")
+	is.NoErr(err)
+	content, err := io.ReadAll(rc)
+	is.NoErr(err)
+	is.Equal(string(content), "AAA")
+}